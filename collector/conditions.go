@@ -0,0 +1,96 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+// HostAttributes describes the host that the When conditions of collectors are evaluated against
+type HostAttributes struct {
+	Hostname string
+	// Tags holds the AWS tags and instance identity attributes of the host
+	Tags map[string]string
+	// HasWmiClass and HasService look up the host's WMI classes and installed services
+	HasWmiClass func(class string) (bool, error)
+	HasService  func(name string) (bool, error)
+}
+
+// LocalHost returns the attributes of this host, with the given AWS tags
+func LocalHost(tags map[string]string) HostAttributes {
+	defer trace()()
+	hostname, _ := os.Hostname()
+	return HostAttributes{
+		Hostname:    hostname,
+		Tags:        tags,
+		HasWmiClass: wmiClassExists,
+		HasService:  serviceExists,
+	}
+}
+
+var wmiClassName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Matches evaluates a condition against the host. It returns whether the condition holds and the first condition that
+// did not, for reporting.
+func (h HostAttributes) Matches(c conf.CollectorCondition) (bool, string, error) {
+	defer trace()()
+	if c.Hostname != "" {
+		re, err := regexp.Compile("(?i)^(?:" + c.Hostname + ")$")
+		if err != nil {
+			return false, "", fmt.Errorf("invalid Hostname pattern: %s", err)
+		}
+		if !re.MatchString(h.Hostname) {
+			return false, fmt.Sprintf("hostname %s does not match %s", h.Hostname, c.Hostname), nil
+		}
+	}
+
+	tags := make([]string, 0, len(c.Tags))
+	for t := range c.Tags {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	for _, t := range tags {
+		re, err := regexp.Compile("^(?:" + c.Tags[t] + ")$")
+		if err != nil {
+			return false, "", fmt.Errorf("invalid pattern for tag %s: %s", t, err)
+		}
+		v, ok := h.Tags[t]
+		if !ok {
+			return false, fmt.Sprintf("tag %s is not set", t), nil
+		}
+		if !re.MatchString(v) {
+			return false, fmt.Sprintf("tag %s=%s does not match %s", t, v, c.Tags[t]), nil
+		}
+	}
+
+	if c.WmiClass != "" {
+		if !wmiClassName.MatchString(c.WmiClass) {
+			return false, "", fmt.Errorf("invalid WMI class name %s", c.WmiClass)
+		}
+		ok, err := h.HasWmiClass(c.WmiClass)
+		if err != nil {
+			return false, "", err
+		}
+		if !ok {
+			return false, fmt.Sprintf("WMI class %s does not exist", c.WmiClass), nil
+		}
+	}
+
+	if c.Service != "" {
+		if !conf.ValidServiceName(c.Service) {
+			return false, "", fmt.Errorf("invalid service name %s", c.Service)
+		}
+		ok, err := h.HasService(strings.TrimSpace(c.Service))
+		if err != nil {
+			return false, "", err
+		}
+		if !ok {
+			return false, fmt.Sprintf("service %s is not installed", c.Service), nil
+		}
+	}
+	return true, "", nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package collector
+
+// wmiClassExists reports no WMI classes, as there is no WMI outside of Windows
+func wmiClassExists(class string) (bool, error) {
+	return false, nil
+}
+
+// serviceExists reports no Windows services outside of Windows
+func serviceExists(name string) (bool, error) {
+	return false, nil
+}
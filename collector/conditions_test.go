@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+func TestHostAttributesMatches(t *testing.T) {
+	host := HostAttributes{
+		Hostname:    "WEB-PROD-01",
+		Tags:        map[string]string{"role": "web", "region": "eu-west-1"},
+		HasWmiClass: func(class string) (bool, error) { return class == "Win32_PerfRawData_W3SVC_WebService", nil },
+		HasService:  func(name string) (bool, error) { return name == "W3SVC" || name == "MSSQL$SQLEXPRESS", nil },
+	}
+	cases := []struct {
+		when     conf.CollectorCondition
+		expected bool
+	}{
+		{conf.CollectorCondition{}, true},
+		{conf.CollectorCondition{Hostname: "web-.*"}, true},
+		{conf.CollectorCondition{Hostname: "web"}, false},
+		{conf.CollectorCondition{Tags: map[string]string{"role": "web|api", "region": "eu-.*"}}, true},
+		{conf.CollectorCondition{Tags: map[string]string{"role": "sql"}}, false},
+		{conf.CollectorCondition{Tags: map[string]string{"team": ".*"}}, false},
+		{conf.CollectorCondition{WmiClass: "Win32_PerfRawData_W3SVC_WebService", Service: "W3SVC"}, true},
+		{conf.CollectorCondition{Hostname: "web-.*", Service: "MSSQLSERVER"}, false},
+		{conf.CollectorCondition{Service: "MSSQL$SQLEXPRESS"}, true},
+		{conf.CollectorCondition{Service: "O'Brien's service"}, false},
+	}
+	for _, c := range cases {
+		ok, reason, err := host.Matches(c.when)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != c.expected {
+			t.Errorf("expected %+v to match %v, got %v (%s)", c.when, c.expected, ok, reason)
+		}
+	}
+
+	if _, _, err := host.Matches(conf.CollectorCondition{WmiClass: "x' OR 1=1"}); err == nil {
+		t.Errorf("expected an invalid WMI class name to be rejected")
+	}
+	if _, _, err := host.Matches(conf.CollectorCondition{Service: `MSSQL\SQLEXPRESS`}); err == nil {
+		t.Errorf("expected an invalid service name to be rejected")
+	}
+}
@@ -0,0 +1,37 @@
+//go:build windows
+// +build windows
+
+package collector
+
+import (
+	"strings"
+
+	"github.com/StackExchange/wmi"
+)
+
+// metaClass is a WMI class definition, queried only to count classes
+type metaClass struct{}
+
+// wmiClassExists tells whether a WMI class is defined in the default namespace
+func wmiClassExists(class string) (bool, error) {
+	defer trace()()
+	var dst []metaClass
+	if err := wmi.Query("SELECT * FROM meta_class WHERE __CLASS = '"+class+"'", &dst); err != nil {
+		return false, err
+	}
+	return len(dst) > 0, nil
+}
+
+// wqlEscaper escapes the characters special to WQL string literals
+var wqlEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// serviceExists tells whether a Windows service is installed
+func serviceExists(name string) (bool, error) {
+	defer trace()()
+	var dst []Win32_Service
+	q := wmi.CreateQuery(&dst, "WHERE Name = '"+wqlEscaper.Replace(name)+"'")
+	if err := wmi.Query(q, &dst); err != nil {
+		return false, err
+	}
+	return len(dst) > 0, nil
+}
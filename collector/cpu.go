@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 // Package collector returns data points from Win32_PerfRawData_PerfOS_Processor
 // https://msdn.microsoft.com/en-us/library/aa394317(v=vs.90).aspx - Win32_PerfRawData_PerfOS_Processor class
 package collector
@@ -21,40 +24,53 @@ type CPUCollector struct {
 	TimeTotal          *prometheus.Desc
 	InterruptsTotal    *prometheus.Desc
 	DPCsTotal          *prometheus.Desc
+
+	coreFilter *instanceFilter
 }
 
 func NewCPUCollector() (Collector, error) {
 	defer trace()()
 	const subsystem = "cpu"
+	filter, err := newInstanceFilter(subsystem, ".+", "")
+	if err != nil {
+		return nil, err
+	}
 	return &CPUCollector{
-		CStateSecondsTotal: prometheus.NewDesc(
+		CStateSecondsTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "cstate_seconds_total"),
 			"Time spent in low-power idle state",
 			GetLabelNames("core", "state"),
 			nil,
 		),
-		TimeTotal: prometheus.NewDesc(
+		TimeTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "time_total"),
 			"Time that processor spent in different modes (idle, user, system, ...)",
 			GetLabelNames("core", "mode"),
 			nil,
 		),
 
-		InterruptsTotal: prometheus.NewDesc(
+		InterruptsTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "interrupts_total"),
 			"Total number of received and serviced hardware interrupts",
 			GetLabelNames("core"),
 			nil,
 		),
-		DPCsTotal: prometheus.NewDesc(
+		DPCsTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "dpcs_total"),
 			"Total number of received and serviced deferred procedure calls (DPCs)",
 			GetLabelNames("core"),
 			nil,
 		),
+		coreFilter: filter,
 	}, nil
 }
 
+// Describe returns the descriptions of the metrics of the collector
+func (c *CPUCollector) Describe() []MetricDesc {
+	defer trace()()
+	return describe(c.CStateSecondsTotal, c.TimeTotal, c.InterruptsTotal, c.DPCsTotal)
+}
+
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *CPUCollector) Collect(ch chan<- prometheus.Metric) error {
@@ -129,7 +145,7 @@ func (c *CPUCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, e
 	for _, data := range dst {
 		log.Println("wmi.cpu->", data.Name)
 
-		if strings.Contains(data.Name, "_Total") {
+		if strings.Contains(data.Name, "_Total") || !c.coreFilter.matches(data.Name) {
 			continue
 		}
 
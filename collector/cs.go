@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 // returns data points from Win32_ComputerSystem
 // https://msdn.microsoft.com/en-us/library/aa394102 - Win32_ComputerSystem class
 
@@ -25,13 +28,13 @@ func NewCSCollector() (Collector, error) {
 	const subsystem = "cs"
 
 	return &CSCollector{
-		LogicalProcessors: prometheus.NewDesc(
+		LogicalProcessors: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "logical_processors"),
 			"ComputerSystem.NumberOfLogicalProcessors",
 			nil,
 			nil,
 		),
-		PhysicalMemoryBytes: prometheus.NewDesc(
+		PhysicalMemoryBytes: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "physical_memory_bytes"),
 			"ComputerSystem.TotalPhysicalMemory",
 			nil,
@@ -40,6 +43,11 @@ func NewCSCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe returns the descriptions of the metrics of the collector
+func (c *CSCollector) Describe() []MetricDesc {
+	return describe(c.PhysicalMemoryBytes, c.LogicalProcessors)
+}
+
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *CSCollector) Collect(ch chan<- prometheus.Metric) error {
@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricDesc is what the descriptor of a metric is built from, which prometheus.Desc keeps to itself
+type MetricDesc struct {
+	FQName string
+	Help   string
+	// LabelNames are the variable labels followed by the constant ones
+	LabelNames []string
+}
+
+var (
+	descsMtx sync.RWMutex
+	// descs records what the descriptors built by newDesc are built from. Descriptors built again from the same
+	// arguments, e.g. whenever the collectors are rebuilt, are shared through descsByKey so that it does not grow.
+	descs      = map[*prometheus.Desc]MetricDesc{}
+	descsByKey = map[string]*prometheus.Desc{}
+)
+
+// newDesc is prometheus.NewDesc, recording what the descriptor is built from for relabeling and collision checks
+func newDesc(fqName, help string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	defer trace()()
+	names := append([]string{}, variableLabels...)
+	consts := make([]string, 0, len(constLabels))
+	for k := range constLabels {
+		consts = append(consts, k)
+	}
+	sort.Strings(consts)
+	key := []string{fqName, help, strings.Join(variableLabels, "\xfe")}
+	for _, k := range consts {
+		names = append(names, k)
+		key = append(key, k+"="+constLabels[k])
+	}
+
+	descsMtx.Lock()
+	defer descsMtx.Unlock()
+	if d, ok := descsByKey[strings.Join(key, "\xff")]; ok {
+		return d
+	}
+	d := prometheus.NewDesc(fqName, help, variableLabels, constLabels)
+	descs[d] = MetricDesc{FQName: fqName, Help: help, LabelNames: names}
+	descsByKey[strings.Join(key, "\xff")] = d
+	return d
+}
+
+// describe returns what descriptors built by newDesc are built from, leaving out nil ones
+func describe(ds ...*prometheus.Desc) []MetricDesc {
+	defer trace()()
+	descsMtx.RLock()
+	defer descsMtx.RUnlock()
+	out := make([]MetricDesc, 0, len(ds))
+	for _, d := range ds {
+		if md, ok := descs[d]; ok {
+			out = append(out, md)
+		}
+	}
+	return out
+}
+
+// describedMetric is a metric along with what its descriptor is built from, for descriptors not built by newDesc
+type describedMetric struct {
+	prometheus.Metric
+	desc MetricDesc
+}
+
+// CollectDescribed collects c, e.g. a metric vector building its own descriptor, into ch with every metric described
+// by desc, so that the metrics can be relabeled
+func CollectDescribed(c prometheus.Collector, desc MetricDesc, ch chan<- prometheus.Metric) {
+	defer trace()()
+	in := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(in)
+		close(in)
+	}()
+	for m := range in {
+		ch <- describedMetric{Metric: m, desc: desc}
+	}
+}
+
+// metricDesc returns what the descriptor of a collected metric is built from, or false if it is not known
+func metricDesc(m prometheus.Metric) (MetricDesc, bool) {
+	defer trace()()
+	if d, ok := m.(describedMetric); ok {
+		return d.desc, true
+	}
+	descsMtx.RLock()
+	defer descsMtx.RUnlock()
+	md, ok := descs[m.Desc()]
+	return md, ok
+}
@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 // returns data points from Win32_PerfRawData_DNS_DNS
 // https://msdn.microsoft.com/en-us/library/ms803992.aspx?f=255&MSPPError=-2147217396
 // https://technet.microsoft.com/en-us/library/cc977686.aspx
@@ -44,133 +47,133 @@ type DNSCollector struct {
 func NewDNSCollector() (Collector, error) {
 	const subsystem = "dns"
 	return &DNSCollector{
-		ZoneTransferRequestsReceived: prometheus.NewDesc(
+		ZoneTransferRequestsReceived: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "zone_transfer_requests_received_total"),
 			"Number of zone transfer requests (AXFR/IXFR) received by the master DNS server",
 			[]string{"qtype"},
 			nil,
 		),
-		ZoneTransferRequestsSent: prometheus.NewDesc(
+		ZoneTransferRequestsSent: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "zone_transfer_requests_sent_total"),
 			"Number of zone transfer requests (AXFR/IXFR) sent by the secondary DNS server",
 			[]string{"qtype"},
 			nil,
 		),
-		ZoneTransferResponsesReceived: prometheus.NewDesc(
+		ZoneTransferResponsesReceived: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "zone_transfer_response_received_total"),
 			"Number of zone transfer responses (AXFR/IXFR) received by the secondary DNS server",
 			[]string{"qtype"},
 			nil,
 		),
-		ZoneTransferSuccessReceived: prometheus.NewDesc(
+		ZoneTransferSuccessReceived: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "zone_transfer_success_received_total"),
 			"Number of successful zone transfers (AXFR/IXFR) received by the secondary DNS server",
 			[]string{"qtype", "protocol"},
 			nil,
 		),
-		ZoneTransferSuccessSent: prometheus.NewDesc(
+		ZoneTransferSuccessSent: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "zone_transfer_success_sent_total"),
 			"Number of successful zone transfers (AXFR/IXFR) of the master DNS server",
 			[]string{"qtype"},
 			nil,
 		),
-		ZoneTransferFailures: prometheus.NewDesc(
+		ZoneTransferFailures: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "zone_transfer_failures_total"),
 			"Number of failed zone transfers of the master DNS server",
 			nil,
 			nil,
 		),
-		MemoryUsedBytes: prometheus.NewDesc(
+		MemoryUsedBytes: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "memory_used_bytes_total"),
 			"Total memory used by DNS server",
 			[]string{"area"},
 			nil,
 		),
-		DynamicUpdatesQueued: prometheus.NewDesc(
+		DynamicUpdatesQueued: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "dynamic_updates_queued"),
 			"Number of dynamic updates queued by the DNS server",
 			nil,
 			nil,
 		),
-		DynamicUpdatesReceived: prometheus.NewDesc(
+		DynamicUpdatesReceived: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "dynamic_updates_received_total"),
 			"Number of secure update requests received by the DNS server",
 			[]string{"operation"},
 			nil,
 		),
-		DynamicUpdatesFailures: prometheus.NewDesc(
+		DynamicUpdatesFailures: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "dynamic_updates_failures_total"),
 			"Number of dynamic updates which timed out or were rejected by the DNS server",
 			[]string{"reason"},
 			nil,
 		),
-		NotifyReceived: prometheus.NewDesc(
+		NotifyReceived: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "notify_received_total"),
 			"Number of notifies received by the secondary DNS server",
 			nil,
 			nil,
 		),
-		NotifySent: prometheus.NewDesc(
+		NotifySent: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "notify_sent_total"),
 			"Number of notifies sent by the master DNS server",
 			nil,
 			nil,
 		),
-		SecureUpdateFailures: prometheus.NewDesc(
+		SecureUpdateFailures: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "secure_update_failures_total"),
 			"Number of secure updates that failed on the DNS server",
 			nil,
 			nil,
 		),
-		SecureUpdateReceived: prometheus.NewDesc(
+		SecureUpdateReceived: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "secure_update_received_total"),
 			"Number of secure update requests received by the DNS server",
 			nil,
 			nil,
 		),
-		Queries: prometheus.NewDesc(
+		Queries: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "queries_total"),
 			"Number of queries received by DNS server",
 			[]string{"protocol"},
 			nil,
 		),
-		Responses: prometheus.NewDesc(
+		Responses: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "responses_total"),
 			"Number of reponses sent by DNS server",
 			[]string{"protocol"},
 			nil,
 		),
-		RecursiveQueries: prometheus.NewDesc(
+		RecursiveQueries: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "recursive_queries_total"),
 			"Number of recursive queries received by DNS server",
 			nil,
 			nil,
 		),
-		RecursiveQueryFailures: prometheus.NewDesc(
+		RecursiveQueryFailures: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "recursive_query_failures_total"),
 			"Number of recursive query failures",
 			nil,
 			nil,
 		),
-		RecursiveQuerySendTimeouts: prometheus.NewDesc(
+		RecursiveQuerySendTimeouts: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "recursive_query_send_timeouts_total"),
 			"Number of recursive query sending timeouts",
 			nil,
 			nil,
 		),
-		WinsQueries: prometheus.NewDesc(
+		WinsQueries: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "wins_queries_total"),
 			"Number of WINS lookup requests received by the server",
 			[]string{"direction"},
 			nil,
 		),
-		WinsResponses: prometheus.NewDesc(
+		WinsResponses: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "wins_responses_total"),
 			"Number of WINS lookup responses sent by the server",
 			[]string{"direction"},
 			nil,
 		),
-		UnmatchedResponsesReceived: prometheus.NewDesc(
+		UnmatchedResponsesReceived: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "unmatched_responses_total"),
 			"Number of response packets received by the DNS server that do not match any outstanding remote query",
 			nil,
@@ -179,6 +182,34 @@ func NewDNSCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe returns the descriptions of the metrics of the collector
+func (c *DNSCollector) Describe() []MetricDesc {
+	return describe(
+		c.ZoneTransferRequestsReceived,
+		c.ZoneTransferRequestsSent,
+		c.ZoneTransferResponsesReceived,
+		c.ZoneTransferSuccessReceived,
+		c.ZoneTransferSuccessSent,
+		c.ZoneTransferFailures,
+		c.MemoryUsedBytes,
+		c.DynamicUpdatesQueued,
+		c.DynamicUpdatesReceived,
+		c.DynamicUpdatesFailures,
+		c.NotifyReceived,
+		c.NotifySent,
+		c.SecureUpdateFailures,
+		c.SecureUpdateReceived,
+		c.Queries,
+		c.Responses,
+		c.RecursiveQueries,
+		c.RecursiveQueryFailures,
+		c.RecursiveQuerySendTimeouts,
+		c.WinsQueries,
+		c.WinsResponses,
+		c.UnmatchedResponsesReceived,
+	)
+}
+
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *DNSCollector) Collect(ch chan<- prometheus.Metric) error {
@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+// An instanceFilter selects the instances a collector reports, such as sites, volumes, NICs, cores or services. An
+// instance is reported if its name matches the include pattern and does not match the exclude pattern.
+type instanceFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+// newInstanceFilter builds the filter of a collector from the Include and Exclude patterns of its CollectorSpec,
+// falling back to the given patterns, e.g. from the older per-collector flags, for any the spec leaves empty
+func newInstanceFilter(subsystem string, include string, exclude string) (*instanceFilter, error) {
+	defer trace()()
+	spec := conf.UCMConfig.Collectors.EnabledCollectors[subsystem]
+	if spec.Include != "" {
+		include = spec.Include
+	}
+	if spec.Exclude != "" {
+		exclude = spec.Exclude
+	}
+	f := &instanceFilter{}
+	var err error
+	if f.include, err = regexp.Compile(fmt.Sprintf("^(?:%s)$", include)); err != nil {
+		return nil, fmt.Errorf("invalid Include pattern for collector %s: %s", subsystem, err)
+	}
+	if f.exclude, err = regexp.Compile(fmt.Sprintf("^(?:%s)$", exclude)); err != nil {
+		return nil, fmt.Errorf("invalid Exclude pattern for collector %s: %s", subsystem, err)
+	}
+	return f, nil
+}
+
+// matches tells whether the instance is reported
+func (f *instanceFilter) matches(name string) bool {
+	return f.include.MatchString(name) && !f.exclude.MatchString(name)
+}
@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+func TestInstanceFilter(t *testing.T) {
+	defer func(c conf.ConfigurationParameters) { conf.UCMConfig = c }(conf.UCMConfig)
+	conf.UCMConfig.Collectors.EnabledCollectors = map[string]conf.CollectorSpec{
+		"logical_disk": {Exclude: "HarddiskVolume[0-9]+"},
+	}
+
+	// the spec sets Exclude, so only the fallback Include applies
+	f, err := newInstanceFilter("logical_disk", "C:|D:|HarddiskVolume.*", "D:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{"C:": true, "D:": true, "E:": false, "HarddiskVolume12": false} {
+		if f.matches(name) != expected {
+			t.Errorf("expected %s to match %v", name, expected)
+		}
+	}
+
+	// the cores tcpu keeps for ComputeLogic aggregates are the ones it reports
+	conf.UCMConfig.Collectors.EnabledCollectors["tcpu"] = conf.CollectorSpec{Exclude: "_Total"}
+	f, err = newInstanceFilter("tcpu", ".+", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{"0": true, "15": true, "_Total": false} {
+		if f.matches(name) != expected {
+			t.Errorf("expected core %s to match %v", name, expected)
+		}
+	}
+
+	conf.UCMConfig.Collectors.EnabledCollectors["service"] = conf.CollectorSpec{Include: "("}
+	if _, err := newInstanceFilter("service", ".+", ""); err == nil {
+		t.Errorf("expected an invalid Include pattern to be rejected")
+	}
+}
@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 // returns data points from Win32_PerfRawData_W3SVC_WebService
 // https://msdn.microsoft.com/en-us/library/aa394345 - Win32_OperatingSystem class
 
@@ -5,9 +8,7 @@ package collector
 
 import (
 	"flag"
-	"fmt"
 	"log"
-	"regexp"
 
 	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
@@ -18,8 +19,8 @@ func init() {
 }
 
 var (
-	siteWhitelist = flag.String("collector.iis.site-whitelist", ".+", "Regexp of sites to whitelist. Site name must both match whitelist and not match blacklist to be included.")
-	siteBlacklist = flag.String("collector.iis.site-blacklist", "", "Regexp of sites to blacklist. Site name must both match whitelist and not match blacklist to be included.")
+	siteWhitelist = flag.String("collector.iis.site-whitelist", ".+", "Regexp of sites to whitelist. Site name must both match whitelist and not match blacklist to be included. Used when the iis collector sets no Include.")
+	siteBlacklist = flag.String("collector.iis.site-blacklist", "", "Regexp of sites to blacklist. Site name must both match whitelist and not match blacklist to be included. Used when the iis collector sets no Exclude.")
 )
 
 // A IISCollector is a Prometheus collector for WMI Win32_PerfRawData_W3SVC_WebService metrics
@@ -47,47 +48,50 @@ type IISCollector struct {
 	TotalNotFoundErrors                 *prometheus.Desc
 	TotalRejectedAsyncIORequests        *prometheus.Desc
 
-	siteWhitelistPattern *regexp.Regexp
-	siteBlacklistPattern *regexp.Regexp
+	siteFilter *instanceFilter
 }
 
 // NewIISCollector ...
 func NewIISCollector() (Collector, error) {
 	const subsystem = "iis"
+	filter, err := newInstanceFilter(subsystem, *siteWhitelist, *siteBlacklist)
+	if err != nil {
+		return nil, err
+	}
 
 	return &IISCollector{
 		// Gauges
-		CurrentAnonymousUsers: prometheus.NewDesc(
+		CurrentAnonymousUsers: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "current_anonymous_users"),
 			"Number of users who currently have an anonymous connection using the Web service (WebService.CurrentAnonymousUsers)",
 			[]string{"site"},
 			nil,
 		),
-		CurrentBlockedAsyncIORequests: prometheus.NewDesc(
+		CurrentBlockedAsyncIORequests: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "current_blocked_async_io_requests"),
 			"Current requests temporarily blocked due to bandwidth throttling settings (WebService.CurrentBlockedAsyncIORequests)",
 			[]string{"site"},
 			nil,
 		),
-		CurrentCGIRequests: prometheus.NewDesc(
+		CurrentCGIRequests: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "current_cgi_requests"),
 			"Current number of CGI requests being simultaneously processed by the Web service (WebService.CurrentCGIRequests)",
 			[]string{"site"},
 			nil,
 		),
-		CurrentConnections: prometheus.NewDesc(
+		CurrentConnections: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "current_connections"),
 			"Current number of connections established with the Web service (WebService.CurrentConnections)",
 			[]string{"site"},
 			nil,
 		),
-		CurrentISAPIExtensionRequests: prometheus.NewDesc(
+		CurrentISAPIExtensionRequests: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "current_isapi_extension_requests"),
 			"Current number of ISAPI requests being simultaneously processed by the Web service (WebService.CurrentISAPIExtensionRequests)",
 			[]string{"site"},
 			nil,
 		),
-		CurrentNonAnonymousUsers: prometheus.NewDesc(
+		CurrentNonAnonymousUsers: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "current_non_anonymous_users"),
 			"Number of users who currently have a non-anonymous connection using the Web service (WebService.CurrentNonAnonymousUsers)",
 			[]string{"site"},
@@ -95,102 +99,128 @@ func NewIISCollector() (Collector, error) {
 		),
 
 		// Counters
-		TotalBytesReceived: prometheus.NewDesc(
+		TotalBytesReceived: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "received_bytes_total"),
 			"Number of data bytes that have been received by the Web service (WebService.TotalBytesReceived)",
 			[]string{"site"},
 			nil,
 		),
-		TotalBytesSent: prometheus.NewDesc(
+		TotalBytesSent: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "sent_bytes_total"),
 			"Number of data bytes that have been sent by the Web service (WebService.TotalBytesSent)",
 			[]string{"site"},
 			nil,
 		),
-		TotalAnonymousUsers: prometheus.NewDesc(
+		TotalAnonymousUsers: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "anonymous_users_total"),
 			"Total number of users who established an anonymous connection with the Web service (WebService.TotalAnonymousUsers)",
 			[]string{"site"},
 			nil,
 		),
-		TotalBlockedAsyncIORequests: prometheus.NewDesc(
+		TotalBlockedAsyncIORequests: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "blocked_async_io_requests_total"),
 			"Total requests temporarily blocked due to bandwidth throttling settings (WebService.TotalBlockedAsyncIORequests)",
 			[]string{"site"},
 			nil,
 		),
-		TotalCGIRequests: prometheus.NewDesc(
+		TotalCGIRequests: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "cgi_requests_total"),
 			"Total CGI requests is the total number of CGI requests (WebService.TotalCGIRequests)",
 			[]string{"site"},
 			nil,
 		),
-		TotalConnectionAttemptsAllInstances: prometheus.NewDesc(
+		TotalConnectionAttemptsAllInstances: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "connection_attempts_all_instances_total"),
 			"Number of connections that have been attempted using the Web service (WebService.TotalConnectionAttemptsAllInstances)",
 			[]string{"site"},
 			nil,
 		),
-		TotalRequests: prometheus.NewDesc(
+		TotalRequests: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "requests_total"),
 			"Number of HTTP requests (WebService.TotalRequests)",
 			[]string{"site", "method"},
 			nil,
 		),
-		TotalFilesReceived: prometheus.NewDesc(
+		TotalFilesReceived: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "files_received_total"),
 			"Number of files received by the Web service (WebService.TotalFilesReceived)",
 			[]string{"site"},
 			nil,
 		),
-		TotalFilesSent: prometheus.NewDesc(
+		TotalFilesSent: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "files_sent_total"),
 			"Number of files sent by the Web service (WebService.TotalFilesSent)",
 			[]string{"site"},
 			nil,
 		),
-		TotalISAPIExtensionRequests: prometheus.NewDesc(
+		TotalISAPIExtensionRequests: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "ipapi_extension_requests_total"),
 			"ISAPI Extension Requests received (WebService.TotalISAPIExtensionRequests)",
 			[]string{"site"},
 			nil,
 		),
-		TotalLockedErrors: prometheus.NewDesc(
+		TotalLockedErrors: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "locked_errors_total"),
 			"Number of requests that couldn't be satisfied by the server because the requested resource was locked (WebService.TotalLockedErrors)",
 			[]string{"site"},
 			nil,
 		),
-		TotalLogonAttempts: prometheus.NewDesc(
+		TotalLogonAttempts: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "logon_attempts_total"),
 			"Number of logons attempts to the Web Service (WebService.TotalLogonAttempts)",
 			[]string{"site"},
 			nil,
 		),
-		TotalNonAnonymousUsers: prometheus.NewDesc(
+		TotalNonAnonymousUsers: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "non_anonymous_users_total"),
 			"Number of users who established a non-anonymous connection with the Web service (WebService.TotalNonAnonymousUsers)",
 			[]string{"site"},
 			nil,
 		),
-		TotalNotFoundErrors: prometheus.NewDesc(
+		TotalNotFoundErrors: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "not_found_errors_total"),
 			"Number of requests that couldn't be satisfied by the server because the requested document could not be found (WebService.TotalNotFoundErrors)",
 			[]string{"site"},
 			nil,
 		),
-		TotalRejectedAsyncIORequests: prometheus.NewDesc(
+		TotalRejectedAsyncIORequests: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "rejected_async_io_requests_total"),
 			"Requests rejected due to bandwidth throttling settings (WebService.TotalRejectedAsyncIORequests)",
 			[]string{"site"},
 			nil,
 		),
 
-		siteWhitelistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *siteWhitelist)),
-		siteBlacklistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *siteBlacklist)),
+		siteFilter: filter,
 	}, nil
 }
 
+// Describe returns the descriptions of the metrics of the collector
+func (c *IISCollector) Describe() []MetricDesc {
+	return describe(
+		c.CurrentAnonymousUsers,
+		c.CurrentBlockedAsyncIORequests,
+		c.CurrentCGIRequests,
+		c.CurrentConnections,
+		c.CurrentISAPIExtensionRequests,
+		c.CurrentNonAnonymousUsers,
+		c.TotalBytesReceived,
+		c.TotalBytesSent,
+		c.TotalAnonymousUsers,
+		c.TotalBlockedAsyncIORequests,
+		c.TotalCGIRequests,
+		c.TotalConnectionAttemptsAllInstances,
+		c.TotalRequests,
+		c.TotalFilesReceived,
+		c.TotalFilesSent,
+		c.TotalISAPIExtensionRequests,
+		c.TotalLockedErrors,
+		c.TotalLogonAttempts,
+		c.TotalNonAnonymousUsers,
+		c.TotalNotFoundErrors,
+		c.TotalRejectedAsyncIORequests,
+	)
+}
+
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *IISCollector) Collect(ch chan<- prometheus.Metric) error {
@@ -254,8 +284,7 @@ func (c *IISCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Desc, e
 
 	for _, site := range dst {
 		if site.Name == "_Total" ||
-			c.siteBlacklistPattern.MatchString(site.Name) ||
-			!c.siteWhitelistPattern.MatchString(site.Name) {
+			!c.siteFilter.matches(site.Name) {
 			continue
 		}
 
@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/djonnala/wmi_exporter/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ExternalLabels attaches constant labels to every collected metric
+type ExternalLabels struct {
+	labels map[string]string
+}
+
+// NewExternalLabels returns ExternalLabels attaching labels, or nil when there are none
+func NewExternalLabels(labels map[string]string) *ExternalLabels {
+	defer trace()()
+	if len(labels) == 0 {
+		return nil
+	}
+	return &ExternalLabels{labels: labels}
+}
+
+// Apply returns the metric with the external labels added. A nil ExternalLabels returns the metric unchanged.
+func (e *ExternalLabels) Apply(m prometheus.Metric) prometheus.Metric {
+	defer trace()()
+	if e == nil {
+		return m
+	}
+	help, labels, metric, ok := decodeMetric(m)
+	if !ok {
+		return m
+	}
+	for k, v := range e.labels {
+		// labels of the collector win, collisions are reported at startup
+		if _, exists := labels[k]; !exists {
+			labels[k] = v
+		}
+	}
+	nm, err := rebuildMetric(labels, help, metric)
+	if err != nil {
+		log.Errorf("Cannot add external labels to metric %s: %s", labels[metricNameLabel], err)
+		return m
+	}
+	return nm
+}
+
+// Collisions returns a description of every external label that a metric of the collectors already carries once
+// relabeled. It works on the descriptions of the collectors rather than on collected metrics, so that it neither
+// depends on what the host reports nor costs a scrape.
+func (e *ExternalLabels) Collisions(collectors map[string]Collector, r *Relabeler) []string {
+	defer trace()()
+	if e == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var collisions []string
+	for name, c := range collectors {
+		for _, d := range c.Describe() {
+			names := make(map[string]bool, len(d.LabelNames))
+			for _, n := range d.LabelNames {
+				names[n] = true
+			}
+			names = r.relabelNames(names)
+			for k := range e.labels {
+				if !names[k] {
+					continue
+				}
+				if col := fmt.Sprintf("%s: metric %s already has label '%s'", name, d.FQName, k); !seen[col] {
+					seen[col] = true
+					collisions = append(collisions, col)
+				}
+			}
+		}
+	}
+	sort.Strings(collisions)
+	return collisions
+}
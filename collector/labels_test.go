@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+func TestExternalLabels(t *testing.T) {
+	e := NewExternalLabels(map[string]string{"datacenter": "eu1", "team": "ops"})
+	volume := newDesc("wmi_logical_disk_free_bytes", "Free space", []string{"volume"}, nil)
+	owned := newDesc("wmi_service_state", "Service state", []string{"team"}, nil)
+
+	m := e.Apply(prometheus.MustNewConstMetric(volume, prometheus.GaugeValue, 1, "C:"))
+	expected := map[string]string{"__name__": "wmi_logical_disk_free_bytes", "volume": "C:", "datacenter": "eu1", "team": "ops"}
+	if got := metricLabels(t, m); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected labels %v, got %v", expected, got)
+	}
+
+	m = prometheus.MustNewConstMetric(owned, prometheus.GaugeValue, 1, "dev")
+	if got := metricLabels(t, e.Apply(m)); got["team"] != "dev" {
+		t.Errorf("expected the collector label to win, got %v", got)
+	}
+
+	var none *ExternalLabels
+	if none.Apply(m) != m || none.Collisions(nil, nil) != nil {
+		t.Errorf("expected nil ExternalLabels to leave metrics unchanged")
+	}
+}
+
+// describedCollector describes its metrics the way the built-in collectors do
+type describedCollector struct {
+	State *prometheus.Desc
+	Info  *prometheus.Desc
+
+	instances *prometheus.Desc
+}
+
+func (c *describedCollector) Collect(ch chan<- prometheus.Metric) error {
+	return nil
+}
+
+func (c *describedCollector) Describe() []MetricDesc {
+	return describe(c.State, c.Info, c.instances)
+}
+
+func TestExternalLabelCollisions(t *testing.T) {
+	e := NewExternalLabels(map[string]string{"team": "ops", "env": "prod"})
+	collectors := map[string]Collector{
+		"service": &describedCollector{
+			State:     newDesc("wmi_service_state", "Service state", []string{"name", "team"}, nil),
+			Info:      newDesc("wmi_service_info", "Service info", nil, prometheus.Labels{"env": "a \"quoted}\" value"}),
+			instances: newDesc("wmi_service_instances", "Service instances", []string{"team"}, nil),
+		},
+	}
+	expected := []string{
+		"service: metric wmi_service_info already has label 'env'",
+		"service: metric wmi_service_instances already has label 'team'",
+		"service: metric wmi_service_state already has label 'team'",
+	}
+	if c := e.Collisions(collectors, nil); !reflect.DeepEqual(c, expected) {
+		t.Errorf("expected collisions %v, got %v", expected, c)
+	}
+
+	r, err := NewRelabeler([]conf.RelabelConfig{
+		{Action: "labeldrop", Regex: "team"},
+		{SourceLabels: []string{"name"}, TargetLabel: "env", Replacement: "$1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{
+		"service: metric wmi_service_info already has label 'env'",
+		"service: metric wmi_service_instances already has label 'env'",
+		"service: metric wmi_service_state already has label 'env'",
+	}
+	if c := e.Collisions(collectors, r); !reflect.DeepEqual(c, expected) {
+		t.Errorf("expected collisions after relabeling %v, got %v", expected, c)
+	}
+}
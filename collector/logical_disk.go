@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 // returns data points from Win32_PerfRawData_PerfDisk_LogicalDisk
 // https://msdn.microsoft.com/en-us/windows/hardware/aa394307(v=vs.71) - Win32_PerfRawData_PerfDisk_LogicalDisk class
 // https://msdn.microsoft.com/en-us/library/ms803973.aspx - LogicalDisk object reference
@@ -6,9 +9,7 @@ package collector
 
 import (
 	"flag"
-	"fmt"
 	"log"
-	"regexp"
 
 	"github.com/StackExchange/wmi"
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,8 +20,8 @@ func init() {
 }
 
 var (
-	volumeWhitelist = flag.String("collector.logical_disk.volume-whitelist", ".+", "Regexp of volumes to whitelist. Volume name must both match whitelist and not match blacklist to be included.")
-	volumeBlacklist = flag.String("collector.logical_disk.volume-blacklist", "", "Regexp of volumes to blacklist. Volume name must both match whitelist and not match blacklist to be included.")
+	volumeWhitelist = flag.String("collector.logical_disk.volume-whitelist", ".+", "Regexp of volumes to whitelist. Volume name must both match whitelist and not match blacklist to be included. Used when the logical_disk collector sets no Include.")
+	volumeBlacklist = flag.String("collector.logical_disk.volume-blacklist", "", "Regexp of volumes to blacklist. Volume name must both match whitelist and not match blacklist to be included. Used when the logical_disk collector sets no Exclude.")
 )
 
 // A LogicalDiskCollector is a Prometheus collector for WMI Win32_PerfRawData_PerfDisk_LogicalDisk metrics
@@ -37,97 +38,116 @@ type LogicalDiskCollector struct {
 	IdleTime        *prometheus.Desc
 	SplitIOs        *prometheus.Desc
 
-	volumeWhitelistPattern *regexp.Regexp
-	volumeBlacklistPattern *regexp.Regexp
+	volumeFilter *instanceFilter
 }
 
 // NewLogicalDiskCollector ...
 func NewLogicalDiskCollector() (Collector, error) {
 	const subsystem = "logical_disk"
+	filter, err := newInstanceFilter(subsystem, *volumeWhitelist, *volumeBlacklist)
+	if err != nil {
+		return nil, err
+	}
 
 	return &LogicalDiskCollector{
-		RequestsQueued: prometheus.NewDesc(
+		RequestsQueued: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "requests_queued"),
 			"The number of requests queued to the disk (LogicalDisk.CurrentDiskQueueLength)",
 			[]string{"volume"},
 			nil,
 		),
 
-		ReadBytesTotal: prometheus.NewDesc(
+		ReadBytesTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "read_bytes_total"),
 			"The number of bytes transferred from the disk during read operations (LogicalDisk.DiskReadBytesPerSec)",
 			[]string{"volume"},
 			nil,
 		),
 
-		ReadsTotal: prometheus.NewDesc(
+		ReadsTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "reads_total"),
 			"The number of read operations on the disk (LogicalDisk.DiskReadsPerSec)",
 			[]string{"volume"},
 			nil,
 		),
 
-		WriteBytesTotal: prometheus.NewDesc(
+		WriteBytesTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "write_bytes_total"),
 			"The number of bytes transferred to the disk during write operations (LogicalDisk.DiskWriteBytesPerSec)",
 			[]string{"volume"},
 			nil,
 		),
 
-		WritesTotal: prometheus.NewDesc(
+		WritesTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "writes_total"),
 			"The number of write operations on the disk (LogicalDisk.DiskWritesPerSec)",
 			[]string{"volume"},
 			nil,
 		),
 
-		ReadTime: prometheus.NewDesc(
+		ReadTime: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "read_seconds_total"),
 			"Seconds that the disk was busy servicing read requests (LogicalDisk.PercentDiskReadTime)",
 			[]string{"volume"},
 			nil,
 		),
 
-		WriteTime: prometheus.NewDesc(
+		WriteTime: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "write_seconds_total"),
 			"Seconds that the disk was busy servicing write requests (LogicalDisk.PercentDiskWriteTime)",
 			[]string{"volume"},
 			nil,
 		),
 
-		FreeSpace: prometheus.NewDesc(
+		FreeSpace: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "free_bytes"),
 			"Free space in bytes (LogicalDisk.PercentFreeSpace)",
 			[]string{"volume"},
 			nil,
 		),
 
-		TotalSpace: prometheus.NewDesc(
+		TotalSpace: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "size_bytes"),
 			"Total space in bytes (LogicalDisk.PercentFreeSpace_Base)",
 			[]string{"volume"},
 			nil,
 		),
 
-		IdleTime: prometheus.NewDesc(
+		IdleTime: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "idle_seconds_total"),
 			"Seconds that the disk was idle (LogicalDisk.PercentIdleTime)",
 			[]string{"volume"},
 			nil,
 		),
 
-		SplitIOs: prometheus.NewDesc(
+		SplitIOs: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "split_ios_total"),
 			"The number of I/Os to the disk were split into multiple I/Os (LogicalDisk.SplitIOPerSec)",
 			[]string{"volume"},
 			nil,
 		),
 
-		volumeWhitelistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *volumeWhitelist)),
-		volumeBlacklistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *volumeBlacklist)),
+		volumeFilter: filter,
 	}, nil
 }
 
+// Describe returns the descriptions of the metrics of the collector
+func (c *LogicalDiskCollector) Describe() []MetricDesc {
+	return describe(
+		c.RequestsQueued,
+		c.ReadBytesTotal,
+		c.ReadsTotal,
+		c.WriteBytesTotal,
+		c.WritesTotal,
+		c.ReadTime,
+		c.WriteTime,
+		c.TotalSpace,
+		c.FreeSpace,
+		c.IdleTime,
+		c.SplitIOs,
+	)
+}
+
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *LogicalDiskCollector) Collect(ch chan<- prometheus.Metric) error {
@@ -162,8 +182,7 @@ func (c *LogicalDiskCollector) collect(ch chan<- prometheus.Metric) (*prometheus
 
 	for _, volume := range dst {
 		if volume.Name == "_Total" ||
-			c.volumeBlacklistPattern.MatchString(volume.Name) ||
-			!c.volumeWhitelistPattern.MatchString(volume.Name) {
+			!c.volumeFilter.matches(volume.Name) {
 			continue
 		}
 
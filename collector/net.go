@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 // returns data points from Win32_PerfRawData_Tcpip_NetworkInterface
 
 // https://technet.microsoft.com/en-us/security/aa394340(v=vs.80) (Win32_PerfRawData_Tcpip_NetworkInterface class)
@@ -8,7 +11,6 @@ package collector
 
 import (
 	"flag"
-	"fmt"
 	"log"
 	"regexp"
 
@@ -21,8 +23,8 @@ func init() {
 }
 
 var (
-	nicWhitelist        = flag.String("collector.net.nic-whitelist", ".+", "Regexp of NIC:s to whitelist. NIC name must both match whitelist and not match blacklist to be included.")
-	nicBlacklist        = flag.String("collector.net.nic-blacklist", "", "Regexp of NIC:s to blacklist. NIC name must both match whitelist and not match blacklist to be included.")
+	nicWhitelist        = flag.String("collector.net.nic-whitelist", ".+", "Regexp of NIC:s to whitelist. NIC name must both match whitelist and not match blacklist to be included. Used when the net collector sets no Include.")
+	nicBlacklist        = flag.String("collector.net.nic-blacklist", "", "Regexp of NIC:s to blacklist. NIC name must both match whitelist and not match blacklist to be included. Used when the net collector sets no Exclude.")
 	nicNameToUnderscore = regexp.MustCompile("[^a-zA-Z0-9]")
 )
 
@@ -40,87 +42,106 @@ type NetworkCollector struct {
 	PacketsReceivedUnknown   *prometheus.Desc
 	PacketsSentTotal         *prometheus.Desc
 
-	nicWhitelistPattern *regexp.Regexp
-	nicBlacklistPattern *regexp.Regexp
+	nicFilter *instanceFilter
 }
 
 // NewNetworkCollector ...
 func NewNetworkCollector() (Collector, error) {
 	const subsystem = "net"
+	filter, err := newInstanceFilter(subsystem, *nicWhitelist, *nicBlacklist)
+	if err != nil {
+		return nil, err
+	}
 
 	return &NetworkCollector{
-		BytesReceivedTotal: prometheus.NewDesc(
+		BytesReceivedTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "bytes_received_total"),
 			"(Network.BytesReceivedPerSec)",
 			[]string{"nic"},
 			nil,
 		),
-		BytesSentTotal: prometheus.NewDesc(
+		BytesSentTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "bytes_sent_total"),
 			"(Network.BytesSentPerSec)",
 			[]string{"nic"},
 			nil,
 		),
-		BytesTotal: prometheus.NewDesc(
+		BytesTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "bytes_total"),
 			"(Network.BytesTotalPerSec)",
 			[]string{"nic"},
 			nil,
 		),
-		PacketsOutboundDiscarded: prometheus.NewDesc(
+		PacketsOutboundDiscarded: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "packets_outbound_discarded"),
 			"(Network.PacketsOutboundDiscarded)",
 			[]string{"nic"},
 			nil,
 		),
-		PacketsOutboundErrors: prometheus.NewDesc(
+		PacketsOutboundErrors: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "packets_outbound_errors"),
 			"(Network.PacketsOutboundErrors)",
 			[]string{"nic"},
 			nil,
 		),
-		PacketsReceivedDiscarded: prometheus.NewDesc(
+		PacketsReceivedDiscarded: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "packets_received_discarded"),
 			"(Network.PacketsReceivedDiscarded)",
 			[]string{"nic"},
 			nil,
 		),
-		PacketsReceivedErrors: prometheus.NewDesc(
+		PacketsReceivedErrors: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "packets_received_errors"),
 			"(Network.PacketsReceivedErrors)",
 			[]string{"nic"},
 			nil,
 		),
-		PacketsReceivedTotal: prometheus.NewDesc(
+		PacketsReceivedTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "packets_received_total"),
 			"(Network.PacketsReceivedPerSec)",
 			[]string{"nic"},
 			nil,
 		),
-		PacketsReceivedUnknown: prometheus.NewDesc(
+		PacketsReceivedUnknown: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "packets_received_unknown"),
 			"(Network.PacketsReceivedUnknown)",
 			[]string{"nic"},
 			nil,
 		),
-		PacketsTotal: prometheus.NewDesc(
+		PacketsTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "packets_total"),
 			"(Network.PacketsPerSec)",
 			[]string{"nic"},
 			nil,
 		),
-		PacketsSentTotal: prometheus.NewDesc(
+		PacketsSentTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "packets_sent_total"),
 			"(Network.PacketsSentPerSec)",
 			[]string{"nic"},
 			nil,
 		),
 
-		nicWhitelistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *nicWhitelist)),
-		nicBlacklistPattern: regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *nicBlacklist)),
+		nicFilter: filter,
 	}, nil
 }
 
+// Describe returns the descriptions of the metrics of the collector
+func (c *NetworkCollector) Describe() []MetricDesc {
+	return describe(
+		c.BytesReceivedTotal,
+		c.BytesSentTotal,
+		c.BytesTotal,
+		c.PacketsOutboundDiscarded,
+		c.PacketsOutboundErrors,
+		c.PacketsTotal,
+		c.PacketsReceivedDiscarded,
+		c.PacketsReceivedErrors,
+		c.PacketsReceivedTotal,
+		c.PacketsReceivedUnknown,
+		c.PacketsSentTotal,
+	)
+}
+
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *NetworkCollector) Collect(ch chan<- prometheus.Metric) error {
@@ -161,8 +182,7 @@ func (c *NetworkCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Des
 	}
 
 	for _, nic := range dst {
-		if c.nicBlacklistPattern.MatchString(nic.Name) ||
-			!c.nicWhitelistPattern.MatchString(nic.Name) {
+		if !c.nicFilter.matches(nic.Name) {
 			continue
 		}
 
@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 // returns data points from Win32_OperatingSystem
 // https://msdn.microsoft.com/en-us/library/aa394239 - Win32_OperatingSystem class
 
@@ -33,61 +36,61 @@ func NewOSCollector() (Collector, error) {
 	const subsystem = "os"
 
 	return &OSCollector{
-		PagingLimitBytes: prometheus.NewDesc(
+		PagingLimitBytes: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "paging_limit_bytes"),
 			"OperatingSystem.SizeStoredInPagingFiles",
 			nil,
 			nil,
 		),
-		PagingFreeBytes: prometheus.NewDesc(
+		PagingFreeBytes: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "paging_free_bytes"),
 			"OperatingSystem.FreeSpaceInPagingFiles",
 			nil,
 			nil,
 		),
-		PhysicalMemoryFreeBytes: prometheus.NewDesc(
+		PhysicalMemoryFreeBytes: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "physical_memory_free_bytes"),
 			"OperatingSystem.FreePhysicalMemory",
 			nil,
 			nil,
 		),
-		Processes: prometheus.NewDesc(
+		Processes: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "processes"),
 			"OperatingSystem.NumberOfProcesses",
 			nil,
 			nil,
 		),
-		ProcessesLimit: prometheus.NewDesc(
+		ProcessesLimit: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "processes_limit"),
 			"OperatingSystem.MaxNumberOfProcesses",
 			nil,
 			nil,
 		),
-		ProcessMemoryLimitBytes: prometheus.NewDesc(
+		ProcessMemoryLimitBytes: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "process_memory_limix_bytes"),
 			"OperatingSystem.MaxProcessMemorySize",
 			nil,
 			nil,
 		),
-		Users: prometheus.NewDesc(
+		Users: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "users"),
 			"OperatingSystem.NumberOfUsers",
 			nil,
 			nil,
 		),
-		VirtualMemoryBytes: prometheus.NewDesc(
+		VirtualMemoryBytes: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "virtual_memory_bytes"),
 			"OperatingSystem.TotalVirtualMemorySize",
 			nil,
 			nil,
 		),
-		VisibleMemoryBytes: prometheus.NewDesc(
+		VisibleMemoryBytes: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "visible_memory_bytes"),
 			"OperatingSystem.TotalVisibleMemorySize",
 			nil,
 			nil,
 		),
-		VirtualMemoryFreeBytes: prometheus.NewDesc(
+		VirtualMemoryFreeBytes: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "virtual_memory_free_bytes"),
 			"OperatingSystem.FreeVirtualMemory",
 			nil,
@@ -96,6 +99,22 @@ func NewOSCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe returns the descriptions of the metrics of the collector
+func (c *OSCollector) Describe() []MetricDesc {
+	return describe(
+		c.PhysicalMemoryFreeBytes,
+		c.PagingFreeBytes,
+		c.VirtualMemoryFreeBytes,
+		c.ProcessesLimit,
+		c.ProcessMemoryLimitBytes,
+		c.Processes,
+		c.Users,
+		c.PagingLimitBytes,
+		c.VirtualMemoryBytes,
+		c.VisibleMemoryBytes,
+	)
+}
+
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *OSCollector) Collect(ch chan<- prometheus.Metric) error {
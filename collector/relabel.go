@@ -0,0 +1,296 @@
+package collector
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/djonnala/wmi_exporter/log"
+)
+
+// metricNameLabel holds the metric name while relabeling, as in Prometheus
+const metricNameLabel = "__name__"
+
+// A Relabeler applies metric relabeling rules to collected metrics before they are exposed
+type Relabeler struct {
+	rules []relabelRule
+}
+
+type relabelRule struct {
+	conf.RelabelConfig
+	regex *regexp.Regexp
+}
+
+// NewRelabeler validates relabeling rules and fills in their defaults. It returns nil when there are no rules.
+func NewRelabeler(configs []conf.RelabelConfig) (*Relabeler, error) {
+	defer trace()()
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	r := &Relabeler{}
+	for i, c := range configs {
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("metric relabel config #%d: %s", i+1, err)
+		}
+		if c.Separator == "" {
+			c.Separator = ";"
+		}
+		if c.Regex == "" {
+			c.Regex = "(.*)"
+		}
+		if c.Replacement == "" {
+			c.Replacement = "$1"
+		}
+		c.Action = strings.ToLower(c.Action)
+		if c.Action == "" {
+			c.Action = "replace"
+		}
+		// Prometheus anchors relabeling regular expressions at both ends
+		re, err := regexp.Compile("^(?:" + c.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("metric relabel config #%d: %s", i+1, err)
+		}
+		r.rules = append(r.rules, relabelRule{RelabelConfig: c, regex: re})
+	}
+	return r, nil
+}
+
+// Relabel applies the rules to a metric, returning the metric unchanged if no label changed, a rebuilt metric if some
+// did, or nil if the metric was dropped. A nil Relabeler keeps every metric.
+func (r *Relabeler) Relabel(m prometheus.Metric) prometheus.Metric {
+	defer trace()()
+	if r == nil {
+		return m
+	}
+	help, labels, metric, ok := decodeMetric(m)
+	if !ok {
+		return m
+	}
+	relabeled := r.process(labels)
+	if relabeled == nil {
+		return nil
+	}
+	if equalLabels(labels, relabeled) {
+		return m
+	}
+
+	nm, err := rebuildMetric(relabeled, help, metric)
+	if err != nil {
+		log.Errorf("Cannot relabel metric %s: %s", labels[metricNameLabel], err)
+		return nil
+	}
+	return nm
+}
+
+// decodeMetric returns the help, the labels including the metric name and the value of a collected metric, or false if
+// the metric cannot be decoded
+func decodeMetric(m prometheus.Metric) (string, map[string]string, *dto.Metric, bool) {
+	defer trace()()
+	d, ok := metricDesc(m)
+	if !ok || d.FQName == "" {
+		return "", nil, nil, false
+	}
+	var metric dto.Metric
+	if err := m.Write(&metric); err != nil {
+		return "", nil, nil, false
+	}
+
+	labels := map[string]string{metricNameLabel: d.FQName}
+	for _, lp := range metric.Label {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+	return d.Help, labels, &metric, true
+}
+
+// process applies the rules to a label set, returning the resulting labels or nil if the series is dropped
+func (r *Relabeler) process(labels map[string]string) map[string]string {
+	defer trace()()
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	for _, rule := range r.rules {
+		if out = rule.apply(out); out == nil {
+			return nil
+		}
+	}
+	for k, v := range out {
+		// empty labels are absent labels, and only the metric name may use the reserved prefix
+		if v == "" || (strings.HasPrefix(k, "__") && k != metricNameLabel) {
+			delete(out, k)
+		}
+	}
+	if out[metricNameLabel] == "" {
+		return nil
+	}
+	return out
+}
+
+func (rule relabelRule) apply(labels map[string]string) map[string]string {
+	values := make([]string, len(rule.SourceLabels))
+	for i, n := range rule.SourceLabels {
+		values[i] = labels[n]
+	}
+	value := strings.Join(values, rule.Separator)
+
+	switch rule.Action {
+	case "keep":
+		if !rule.regex.MatchString(value) {
+			return nil
+		}
+	case "drop":
+		if rule.regex.MatchString(value) {
+			return nil
+		}
+	case "replace":
+		idx := rule.regex.FindStringSubmatchIndex(value)
+		if idx == nil {
+			break
+		}
+		target := string(rule.regex.ExpandString(nil, rule.TargetLabel, value, idx))
+		if !labelName.MatchString(target) {
+			break
+		}
+		res := string(rule.regex.ExpandString(nil, rule.Replacement, value, idx))
+		if res == "" {
+			delete(labels, target)
+			break
+		}
+		labels[target] = res
+	case "hashmod":
+		sum := md5.Sum([]byte(value))
+		labels[rule.TargetLabel] = strconv.FormatUint(binary.BigEndian.Uint64(sum[8:])%rule.Modulus, 10)
+	case "labelmap":
+		mapped := make(map[string]string)
+		for k, v := range labels {
+			if rule.regex.MatchString(k) {
+				mapped[rule.regex.ReplaceAllString(k, rule.Replacement)] = v
+			}
+		}
+		for k, v := range mapped {
+			labels[k] = v
+		}
+	case "labeldrop":
+		for k := range labels {
+			if k != metricNameLabel && rule.regex.MatchString(k) {
+				delete(labels, k)
+			}
+		}
+	case "labelkeep":
+		for k := range labels {
+			if k != metricNameLabel && !rule.regex.MatchString(k) {
+				delete(labels, k)
+			}
+		}
+	}
+	return labels
+}
+
+// relabelNames returns the label names left once the rules acting on label names applied, and the targets of the
+// rules that may set a label. Rules on label values cannot be evaluated without values, they keep every label.
+func (r *Relabeler) relabelNames(names map[string]bool) map[string]bool {
+	defer trace()()
+	if r == nil {
+		return names
+	}
+	out := make(map[string]bool, len(names))
+	for k := range names {
+		out[k] = true
+	}
+	for _, rule := range r.rules {
+		switch rule.Action {
+		case "replace", "hashmod":
+			if labelName.MatchString(rule.TargetLabel) {
+				out[rule.TargetLabel] = true
+			}
+		case "labelmap":
+			for k := range out {
+				if rule.regex.MatchString(k) {
+					out[rule.regex.ReplaceAllString(k, rule.Replacement)] = true
+				}
+			}
+		case "labeldrop":
+			for k := range out {
+				if rule.regex.MatchString(k) {
+					delete(out, k)
+				}
+			}
+		case "labelkeep":
+			for k := range out {
+				if !rule.regex.MatchString(k) {
+					delete(out, k)
+				}
+			}
+		}
+	}
+	return out
+}
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func equalLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// rebuildMetric builds a constant metric with the given labels and the value of a collected metric. The metric carries
+// its description, so that it can be rewritten again.
+func rebuildMetric(labels map[string]string, help string, metric *dto.Metric) (prometheus.Metric, error) {
+	defer trace()()
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		if k != metricNameLabel {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, n := range names {
+		values[i] = labels[n]
+	}
+	desc := prometheus.NewDesc(labels[metricNameLabel], help, names, nil)
+
+	var m prometheus.Metric
+	var err error
+	switch {
+	case metric.Gauge != nil:
+		m, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, metric.Gauge.GetValue(), values...)
+	case metric.Counter != nil:
+		m, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, metric.Counter.GetValue(), values...)
+	case metric.Untyped != nil:
+		m, err = prometheus.NewConstMetric(desc, prometheus.UntypedValue, metric.Untyped.GetValue(), values...)
+	case metric.Summary != nil:
+		quantiles := make(map[float64]float64, len(metric.Summary.Quantile))
+		for _, q := range metric.Summary.Quantile {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		m, err = prometheus.NewConstSummary(desc, metric.Summary.GetSampleCount(), metric.Summary.GetSampleSum(), quantiles, values...)
+	case metric.Histogram != nil:
+		buckets := make(map[float64]uint64, len(metric.Histogram.Bucket))
+		for _, b := range metric.Histogram.Bucket {
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+		m, err = prometheus.NewConstHistogram(desc, metric.Histogram.GetSampleCount(), metric.Histogram.GetSampleSum(), buckets, values...)
+	default:
+		return nil, fmt.Errorf("unsupported metric type")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return describedMetric{Metric: m, desc: MetricDesc{FQName: labels[metricNameLabel], Help: help, LabelNames: names}}, nil
+}
@@ -0,0 +1,146 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+func TestRelabel(t *testing.T) {
+	volume := newDesc("wmi_logical_disk_free_bytes", "Free space", []string{"volume"}, nil)
+	state := newDesc("wmi_service_state", "Service state", []string{"name", "state"}, prometheus.Labels{"host_dc": "x"})
+	metric := func(d *prometheus.Desc, lvs ...string) prometheus.Metric {
+		return prometheus.MustNewConstMetric(d, prometheus.GaugeValue, 42, lvs...)
+	}
+
+	cases := []struct {
+		name     string
+		rules    []conf.RelabelConfig
+		metric   prometheus.Metric
+		expected map[string]string
+	}{
+		{
+			name:     "no change",
+			rules:    []conf.RelabelConfig{{Action: "keep", SourceLabels: []string{"volume"}, Regex: "C:"}},
+			metric:   metric(volume, "C:"),
+			expected: map[string]string{"__name__": "wmi_logical_disk_free_bytes", "volume": "C:"},
+		},
+		{
+			name:   "keep",
+			rules:  []conf.RelabelConfig{{Action: "keep", SourceLabels: []string{"volume"}, Regex: "C:"}},
+			metric: metric(volume, "D:"),
+		},
+		{
+			name:   "drop",
+			rules:  []conf.RelabelConfig{{Action: "drop", SourceLabels: []string{"__name__"}, Regex: "wmi_logical_disk_.*"}},
+			metric: metric(volume, "C:"),
+		},
+		{
+			name:     "replace",
+			rules:    []conf.RelabelConfig{{SourceLabels: []string{"volume"}, Regex: "HarddiskVolume[0-9]+", TargetLabel: "volume", Replacement: "unmounted"}},
+			metric:   metric(volume, "HarddiskVolume12"),
+			expected: map[string]string{"__name__": "wmi_logical_disk_free_bytes", "volume": "unmounted"},
+		},
+		{
+			name:     "replace with groups and separator",
+			rules:    []conf.RelabelConfig{{SourceLabels: []string{"name", "state"}, Separator: "/", Regex: "(.*)/(.*)", TargetLabel: "service", Replacement: "${1}_$2"}},
+			metric:   metric(state, "dhcp", "running"),
+			expected: map[string]string{"__name__": "wmi_service_state", "name": "dhcp", "state": "running", "host_dc": "x", "service": "dhcp_running"},
+		},
+		{
+			name:     "rename metric",
+			rules:    []conf.RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: "wmi_(.*)", TargetLabel: "__name__", Replacement: "windows_$1"}},
+			metric:   metric(volume, "C:"),
+			expected: map[string]string{"__name__": "windows_logical_disk_free_bytes", "volume": "C:"},
+		},
+		{
+			name:     "hashmod",
+			rules:    []conf.RelabelConfig{{Action: "hashmod", SourceLabels: []string{"volume"}, Modulus: 8, TargetLabel: "shard"}},
+			metric:   metric(volume, "C:"),
+			expected: map[string]string{"__name__": "wmi_logical_disk_free_bytes", "volume": "C:", "shard": "5"},
+		},
+		{
+			name:     "labelmap",
+			rules:    []conf.RelabelConfig{{Action: "labelmap", Regex: "host_(.*)"}},
+			metric:   metric(state, "dhcp", "running"),
+			expected: map[string]string{"__name__": "wmi_service_state", "name": "dhcp", "state": "running", "host_dc": "x", "dc": "x"},
+		},
+		{
+			name:     "labeldrop",
+			rules:    []conf.RelabelConfig{{Action: "labeldrop", Regex: "state"}},
+			metric:   metric(state, "dhcp", "running"),
+			expected: map[string]string{"__name__": "wmi_service_state", "name": "dhcp", "host_dc": "x"},
+		},
+		{
+			name:     "labelkeep",
+			rules:    []conf.RelabelConfig{{Action: "labelkeep", Regex: "state|host_.*"}},
+			metric:   metric(state, "dhcp", "running"),
+			expected: map[string]string{"__name__": "wmi_service_state", "state": "running", "host_dc": "x"},
+		},
+	}
+
+	for _, c := range cases {
+		r, err := NewRelabeler(c.rules)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		m := r.Relabel(c.metric)
+		if c.expected == nil {
+			if m != nil {
+				t.Errorf("%s: expected metric to be dropped", c.name)
+			}
+			continue
+		}
+		if m == nil {
+			t.Errorf("%s: metric was dropped", c.name)
+			continue
+		}
+		if got := metricLabels(t, m); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: expected labels %v, got %v", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestRelabelKeepsValue(t *testing.T) {
+	r, err := NewRelabeler([]conf.RelabelConfig{{Action: "labeldrop", Regex: "core"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hist := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "wmi_cpu_idle", Help: "Idle", Buckets: []float64{1, 2}}, []string{"core"})
+	hist.WithLabelValues("0").Observe(1.5)
+	ch := make(chan prometheus.Metric, 1)
+	CollectDescribed(hist, MetricDesc{FQName: "wmi_cpu_idle", Help: "Idle", LabelNames: []string{"core"}}, ch)
+
+	var out dto.Metric
+	if err := r.Relabel(<-ch).Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Label) != 0 || out.Histogram.GetSampleCount() != 1 || out.Histogram.Bucket[1].GetCumulativeCount() != 1 {
+		t.Errorf("unexpected relabeled histogram %v", out)
+	}
+}
+
+func TestNewRelabelerInvalid(t *testing.T) {
+	for _, rule := range []conf.RelabelConfig{
+		{Action: "rename"},
+		{Action: "replace"},
+		{Action: "hashmod", TargetLabel: "shard"},
+		{Action: "keep", Regex: "("},
+	} {
+		if _, err := NewRelabeler([]conf.RelabelConfig{rule}); err == nil {
+			t.Errorf("expected %+v to be rejected", rule)
+		}
+	}
+}
+
+func metricLabels(t *testing.T, m prometheus.Metric) map[string]string {
+	_, labels, _, ok := decodeMetric(m)
+	if !ok {
+		t.Fatalf("cannot decode metric %s", m.Desc())
+	}
+	return labels
+}
@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 // returns data points from Win32_Service
 // https://msdn.microsoft.com/en-us/library/aa394418(v=vs.85).aspx - Win32_Service class
 package collector
@@ -18,27 +21,39 @@ func init() {
 type serviceCollector struct {
 	State     *prometheus.Desc
 	StartMode *prometheus.Desc
+
+	nameFilter *instanceFilter
 }
 
 // NewserviceCollector ...
 func NewserviceCollector() (Collector, error) {
 	const subsystem = "service"
+	filter, err := newInstanceFilter(subsystem, ".+", "")
+	if err != nil {
+		return nil, err
+	}
 	return &serviceCollector{
-		State: prometheus.NewDesc(
+		State: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "state"),
 			"The state of the service (State)",
 			[]string{"name", "state"},
 			nil,
 		),
-		StartMode: prometheus.NewDesc(
+		StartMode: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "start_mode"),
 			"The start mode of the service (StartMode)",
 			[]string{"name", "start_mode"},
 			nil,
 		),
+		nameFilter: filter,
 	}, nil
 }
 
+// Describe returns the descriptions of the metrics of the collector
+func (c *serviceCollector) Describe() []MetricDesc {
+	return describe(c.State, c.StartMode)
+}
+
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *serviceCollector) Collect(ch chan<- prometheus.Metric) error {
@@ -83,6 +98,9 @@ func (c *serviceCollector) collect(ch chan<- prometheus.Metric) (*prometheus.Des
 	}
 
 	for _, service := range dst {
+		if !c.nameFilter.matches(strings.ToLower(service.Name)) {
+			continue
+		}
 		for _, state := range allStates {
 			isCurrentState := 0.0
 			if state == strings.ToLower(service.State) {
@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 // returns data points from Win32_PerfRawData_PerfOS_System class
 // https://web.archive.org/web/20050830140516/http://msdn.microsoft.com/library/en-us/wmisdk/wmi/win32_perfrawdata_perfos_system.asp
 
@@ -29,37 +32,37 @@ func NewSystemCollector() (Collector, error) {
 	const subsystem = "system"
 
 	return &SystemCollector{
-		ContextSwitchesTotal: prometheus.NewDesc(
+		ContextSwitchesTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "context_switches_total"),
 			"PerfOS_System.ContextSwitchesPersec",
 			nil,
 			nil,
 		),
-		ExceptionDispatchesTotal: prometheus.NewDesc(
+		ExceptionDispatchesTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "exception_dispatches_total"),
 			"PerfOS_System.ExceptionDispatchesPersec",
 			nil,
 			nil,
 		),
-		ProcessorQueueLength: prometheus.NewDesc(
+		ProcessorQueueLength: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "processor_queue_length"),
 			"PerfOS_System.ProcessorQueueLength",
 			nil,
 			nil,
 		),
-		SystemCallsTotal: prometheus.NewDesc(
+		SystemCallsTotal: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "system_calls_total"),
 			"PerfOS_System.SystemCallsPersec",
 			nil,
 			nil,
 		),
-		SystemUpTime: prometheus.NewDesc(
+		SystemUpTime: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "system_up_time"),
 			"SystemUpTime/Frequency_Object",
 			nil,
 			nil,
 		),
-		Threads: prometheus.NewDesc(
+		Threads: newDesc(
 			prometheus.BuildFQName(Namespace, subsystem, "threads"),
 			"PerfOS_System.Threads",
 			nil,
@@ -68,6 +71,18 @@ func NewSystemCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe returns the descriptions of the metrics of the collector
+func (c *SystemCollector) Describe() []MetricDesc {
+	return describe(
+		c.ContextSwitchesTotal,
+		c.ExceptionDispatchesTotal,
+		c.ProcessorQueueLength,
+		c.SystemCallsTotal,
+		c.SystemUpTime,
+		c.Threads,
+	)
+}
+
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *SystemCollector) Collect(ch chan<- prometheus.Metric) error {
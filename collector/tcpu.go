@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 // Package collector returns data points from Win32_PerfRawData_PerfOS_Processor
 // https://msdn.microsoft.com/en-us/library/aa394317(v=vs.90).aspx - Win32_PerfRawData_PerfOS_Processor class
 package collector
@@ -35,13 +38,18 @@ type Win32_PerfFormattedData_PerfOS_Processor struct {
 
 // A CPUCollector is a Prometheus collector for WMI Win32_PerfFormattedData_PerfOS_Processor metrics
 type tCPUCollector struct {
-	lmap map[string]Win32_PerfFormattedData_PerfOS_Processor
+	lmap       map[string]Win32_PerfFormattedData_PerfOS_Processor
+	coreFilter *instanceFilter
 }
 
 // TestTemplateCollector is a test collector to validate templated collection
 func cpuTemplateCollector() (Collector, error) {
 	defer trace()()
-	return NewTemplateCollector(tcpuSubsystem, &tCPUCollector{})
+	filter, err := newInstanceFilter(tcpuSubsystem, ".+", "")
+	if err != nil {
+		return nil, err
+	}
+	return NewTemplateCollector(tcpuSubsystem, &tCPUCollector{coreFilter: filter})
 }
 
 func (c *tCPUCollector) getValue(varname string) interface{} {
@@ -132,33 +140,38 @@ func (c *tCPUCollector) getCoreValue(name, core, mode, state string) interface{}
 	return 0.
 }
 
+func (c *tCPUCollector) clone() CollectableTemplate {
+	defer trace()()
+	return &tCPUCollector{coreFilter: c.coreFilter}
+}
+
 func (c *tCPUCollector) getMetricDesc(m map[string]*prometheus.Desc) error {
 	defer trace()()
-	m["cstate_seconds_total"] = prometheus.NewDesc(
+	m["cstate_seconds_total"] = newDesc(
 		prometheus.BuildFQName(Namespace, tcpuSubsystem, "cstate_seconds_total"),
 		"Time spent in low-power idle state",
 		GetLabelNames("core", "state"),
 		nil,
 	)
-	m["time_total"] = prometheus.NewDesc(
+	m["time_total"] = newDesc(
 		prometheus.BuildFQName(Namespace, tcpuSubsystem, "time_total"),
 		"Time that processor spent in different modes (idle, user, system, ...)",
 		GetLabelNames("core", "mode"),
 		nil,
 	)
-	m["interrupts_total"] = prometheus.NewDesc(
+	m["interrupts_total"] = newDesc(
 		prometheus.BuildFQName(Namespace, tcpuSubsystem, "interrupts_total"),
 		"Total number of received and serviced hardware interrupts",
 		GetLabelNames("core"),
 		nil,
 	)
-	m["dpcs_total"] = prometheus.NewDesc(
+	m["dpcs_total"] = newDesc(
 		prometheus.BuildFQName(Namespace, tcpuSubsystem, "dpcs_total"),
 		"Total number of received and serviced deferred procedure calls (DPCs)",
 		GetLabelNames("core"),
 		nil,
 	)
-	m["raw_metrics"] = prometheus.NewDesc(
+	m["raw_metrics"] = newDesc(
 		prometheus.BuildFQName(Namespace, tcpuSubsystem, "raw_metrics"),
 		"Raw metrics returned from WMI",
 		GetLabelNames("core", "wminame"),
@@ -200,6 +213,22 @@ type Win32_PerfRawData_Counters_ProcessorInformation struct {
 	ProcessorStateFlags         uint64
 }*/
 
+// update keeps the values of the cores the filter selects, so that excluded cores are neither reported nor part of
+// ComputeLogic aggregates
+func (c *tCPUCollector) update(dst []Win32_PerfFormattedData_PerfOS_Processor) {
+	defer trace()()
+	if c.lmap == nil {
+		c.lmap = make(map[string]Win32_PerfFormattedData_PerfOS_Processor, len(dst))
+	}
+	for _, data := range dst {
+		if c.coreFilter.matches(data.Name) {
+			c.lmap[data.Name] = data
+		} else {
+			delete(c.lmap, data.Name)
+		}
+	}
+}
+
 func (c *tCPUCollector) collect(m map[string]*prometheus.Desc, ch chan<- prometheus.Metric) (CollectableTemplate, error) {
 	defer trace()()
 	var dst []Win32_PerfFormattedData_PerfOS_Processor
@@ -208,13 +237,12 @@ func (c *tCPUCollector) collect(m map[string]*prometheus.Desc, ch chan<- prometh
 		return nil, err
 	}
 
-	if c.lmap == nil {
-		c.lmap = make(map[string]Win32_PerfFormattedData_PerfOS_Processor, len(dst))
-	}
-
+	c.update(dst)
 	for _, data := range dst {
-		c.lmap[data.Name] = data
 		core := data.Name
+		if !c.coreFilter.matches(core) {
+			continue
+		}
 		if _, ok := m["cstate_seconds_total"]; ok {
 			ch <- prometheus.MustNewConstMetric(
 				m["cstate_seconds_total"],
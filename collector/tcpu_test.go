@@ -0,0 +1,29 @@
+//go:build windows
+// +build windows
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+func TestTCPUCoreFilter(t *testing.T) {
+	defer func(c conf.ConfigurationParameters) { conf.UCMConfig = c }(conf.UCMConfig)
+	conf.UCMConfig.Collectors.EnabledCollectors = map[string]conf.CollectorSpec{"tcpu": {Exclude: "_Total"}}
+	f, err := newInstanceFilter("tcpu", ".+", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &tCPUCollector{coreFilter: f}
+	c.update([]Win32_PerfFormattedData_PerfOS_Processor{
+		{Name: "0", PercentIdleTime: 10},
+		{Name: "1", PercentIdleTime: 20},
+		{Name: "_Total", PercentIdleTime: 15},
+	})
+	idle := c.getValue("PercentIdleTime").([]interface{})
+	if len(idle) != 2 {
+		t.Errorf("expected the excluded core to be left out of aggregates, got %v", idle)
+	}
+}
@@ -1,13 +1,17 @@
 package collector
 
 import (
+	"fmt"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Knetic/govaluate"
-	"github.com/prometheus/common/log"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/djonnala/wmi_exporter/log"
 	"github.com/djonnala/wmi_exporter/utils"
 )
 
@@ -17,6 +21,9 @@ func NewTemplateCollector(subsystem string, t CollectableTemplate) (Collector, e
 	coll := TemplateCollector{
 		metricDescList: make(map[string]*prometheus.Desc),
 		metricExprList: make(map[string]*govaluate.EvaluableExpression),
+		metricDistList: make(map[string]*distribution),
+		metricHistList: make(map[string]*smoothingHistory),
+		sampleList:     make(map[string]*sampleWindow),
 		tobj:           t,
 	}
 
@@ -26,18 +33,43 @@ func NewTemplateCollector(subsystem string, t CollectableTemplate) (Collector, e
 		t.getMetricDesc(coll.metricDescList)
 	}
 	for _, k := range v.ExportedMetrics {
-		coll.metricDescList[k.ExportName] = prometheus.NewDesc(
-			prometheus.BuildFQName(v.Namespace, subsystem, k.ExportName),
-			"Dynamic help for "+k.ExportName+" from config - "+k.Desc,
-			GetLabelNames(),
-			nil,
-		)
+		// configurations loaded without -config.check, e.g. from Consul KV, must not make scrapes panic
+		if len(k.SourceName) == 0 && k.NeedsSource() {
+			return nil, fmt.Errorf("exported metric '%s' of collector '%s' has no SourceName", k.ExportName, subsystem)
+		}
+		if err := checkLabelNames(subsystem, k); err != nil {
+			return nil, err
+		}
+		if d := newDistribution(v.Namespace, subsystem, k); d != nil {
+			coll.metricDistList[k.ExportName] = d
+		} else if v.SampleInterval > 0 && exportedLabelNames(k) == nil {
+			coll.sampleList[k.ExportName] = newSampleWindow(v.Namespace, subsystem, k)
+		} else {
+			coll.metricDescList[k.ExportName] = newDesc(
+				prometheus.BuildFQName(v.Namespace, subsystem, k.ExportName),
+				"Dynamic help for "+k.ExportName+" from config - "+k.Desc,
+				GetLabelNames(exportedLabelNames(k)...),
+				nil,
+			)
+		}
 		coll.metricMapList = append(coll.metricMapList, k)
-		expr, err := govaluate.NewEvaluableExpressionWithFunctions(k.ComputeLogic, functions)
+		hist := newSmoothingHistory()
+		coll.metricHistList[k.ExportName] = hist
+		expr, err := hist.parse(k.ComputeLogic)
 		if err != nil {
 			log.Errorln("Error parsing metric expression for custom metric", k.ExportName, ". Error=>", err)
 		}
 		coll.metricExprList[k.ExportName] = expr
+		if err := checkNumericSources(subsystem, k, expr); err != nil {
+			return nil, err
+		}
+	}
+
+	// sample numeric metrics in the background between scrapes, if requested
+	if len(coll.sampleList) > 0 {
+		coll.sampleObj = t.clone()
+		coll.stopCh = make(chan struct{})
+		go coll.sample(time.Duration(v.SampleInterval)*time.Second, coll.stopCh)
 	}
 
 	// return processed struct
@@ -49,29 +81,172 @@ type TemplateCollector struct {
 	metricDescList map[string]*prometheus.Desc
 	metricMapList  []conf.MetricMap
 	metricExprList map[string]*govaluate.EvaluableExpression
+	metricDistList map[string]*distribution
+	metricHistList map[string]*smoothingHistory
+	sampleList     map[string]*sampleWindow
 	tobj           CollectableTemplate
+	// sampleObj is the template the background sampler queries, so that it can do so without holding mtx
+	sampleObj CollectableTemplate
+
+	// mtx guards tobj, the smoothing history and sampleList against the background sampler
+	mtx    sync.Mutex
+	stopCh chan struct{}
+}
+
+// A distribution keeps a histogram or summary of a configured metric, sampled once per collection cycle
+type distribution struct {
+	vec     prometheus.Collector
+	desc    MetricDesc
+	observe func(v float64, lvs ...string)
+}
+
+// newDistribution returns a distribution for metrics exported as Histogram or Summary, or nil for all other metric types
+func newDistribution(namespace, subsystem string, m conf.MetricMap) *distribution {
+	defer trace()()
+	help := "Dynamic help for " + m.ExportName + " from config - " + m.Desc
+	desc := MetricDesc{FQName: prometheus.BuildFQName(namespace, subsystem, m.ExportName), Help: help, LabelNames: GetLabelNames()}
+	switch m.MetricType {
+	case "Histogram":
+		vec := prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Subsystem: subsystem,
+				Name:      m.ExportName,
+				Help:      help,
+				Buckets:   m.Buckets,
+			},
+			GetLabelNames(),
+		)
+		return &distribution{
+			vec:     vec,
+			desc:    desc,
+			observe: func(v float64, lvs ...string) { vec.WithLabelValues(lvs...).Observe(v) },
+		}
+	case "Summary":
+		var objectives map[float64]float64
+		if len(m.Objectives) > 0 {
+			objectives = make(map[float64]float64, len(m.Objectives))
+			for _, o := range m.Objectives {
+				objectives[o.Quantile] = o.Error
+			}
+		}
+		vec := prometheus.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Namespace:  namespace,
+				Subsystem:  subsystem,
+				Name:       m.ExportName,
+				Help:       help,
+				Objectives: objectives,
+			},
+			GetLabelNames(),
+		)
+		return &distribution{
+			vec:     vec,
+			desc:    desc,
+			observe: func(v float64, lvs ...string) { vec.WithLabelValues(lvs...).Observe(v) },
+		}
+	}
+	return nil
 }
 
 // Collect sends the metric values for each metric
 // to the provided prometheus Metric channel.
 func (c *TemplateCollector) Collect(ch chan<- prometheus.Metric) error {
 	defer trace()()
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 
 	//populate with any built-in metrics
-	ct, _ := c.tobj.collect(c.metricDescList, ch)
+	ct, err := c.tobj.collect(c.metricDescList, ch)
+	if err != nil {
+		c.resetHistory()
+		return err
+	}
 
 	//compute all overridden metrics
 	for _, v := range c.metricMapList {
+		switch v.MetricType {
+		case "Info":
+			lvs := make([]string, len(v.SourceName))
+			for i, n := range v.SourceName {
+				lvs[i] = toLabelValue(ct.getValue(n))
+			}
+			ch <- prometheus.MustNewConstMetric(
+				c.metricDescList[v.ExportName],
+				prometheus.GaugeValue,
+				1,
+				GetLabelValues(lvs...)...,
+			)
+			continue
+		case "StateSet":
+			current := strings.ToLower(toLabelValue(ct.getValue(v.SourceName[0])))
+			for _, state := range v.States {
+				isCurrentState := 0.0
+				if strings.ToLower(state) == current {
+					isCurrentState = 1.0
+				}
+				ch <- prometheus.MustNewConstMetric(
+					c.metricDescList[v.ExportName],
+					prometheus.GaugeValue,
+					isCurrentState,
+					GetLabelValues(state)...,
+				)
+			}
+			continue
+		}
+		if w, ok := c.sampleList[v.ExportName]; ok {
+			w.collect(ch)
+			continue
+		}
+		val, err := compute(ct, v, c.metricExprList[v.ExportName])
+		if err != nil {
+			log.Errorf("Error computing metric %s. Error=%s", v.ExportName, err)
+			continue
+		}
+		if d, ok := c.metricDistList[v.ExportName]; ok {
+			d.observe(val, GetLabelValues()...)
+			continue
+		}
 		ch <- prometheus.MustNewConstMetric(
 			c.metricDescList[v.ExportName],
 			getType(v.MetricType),
-			compute(ct, v, c.metricExprList[v.ExportName]),
+			val,
 			GetLabelValues()...,
 		)
 	}
+
+	//expose the distributions sampled so far
+	for _, d := range c.metricDistList {
+		CollectDescribed(d.vec, d.desc, ch)
+	}
 	return nil
 }
 
+// Describe returns the descriptions of the exported metrics, of their distributions and of their sampled aggregates
+func (c *TemplateCollector) Describe() []MetricDesc {
+	defer trace()()
+	descs := make([]*prometheus.Desc, 0, len(c.metricDescList))
+	for _, d := range c.metricDescList {
+		descs = append(descs, d)
+	}
+	for _, w := range c.sampleList {
+		descs = append(descs, w.minDesc, w.maxDesc, w.avgDesc, w.lastDesc)
+	}
+	out := describe(descs...)
+	for _, d := range c.metricDistList {
+		out = append(out, d.desc)
+	}
+	return out
+}
+
+// resetHistory drops the smoothing history of all expressions, as their source series are gone
+func (c *TemplateCollector) resetHistory() {
+	defer trace()()
+	for _, h := range c.metricHistList {
+		h.reset()
+	}
+}
+
 // GetLabelNames builds Label names with configured labels from tags and metric-specific labels
 func GetLabelNames(m ...string) []string {
 	defer trace()()
@@ -84,6 +259,59 @@ func GetLabelValues(m ...string) []string {
 	return append(utils.TagLabelValues, m...)
 }
 
+var invalidLabelChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// exportedLabelNames returns the metric-specific labels of a configured metric. Info metrics carry one label per source property,
+// named after the property, or after the whole source name with its instance selector when several sources read the same property.
+// StateSet metrics carry a single label named after the metric holding the enumerated state.
+func exportedLabelNames(m conf.MetricMap) []string {
+	defer trace()()
+	switch m.MetricType {
+	case "Info":
+		n := make([]string, len(m.SourceName))
+		count := make(map[string]int)
+		for i, s := range m.SourceName {
+			name, _ := ProcessVarName(s)
+			n[i] = strings.ToLower(invalidLabelChars.ReplaceAllString(name, "_"))
+			count[n[i]]++
+		}
+		for i, s := range m.SourceName {
+			if count[n[i]] > 1 {
+				n[i] = strings.ToLower(invalidLabelChars.ReplaceAllString(s, "_"))
+			}
+		}
+		return n
+	case "StateSet":
+		return []string{m.ExportName}
+	}
+	return nil
+}
+
+// checkLabelNames fails if a metric would carry a label twice, which NewDesc accepts but makes every Gather fail
+func checkLabelNames(subsystem string, m conf.MetricMap) error {
+	defer trace()()
+	seen := make(map[string]bool)
+	for _, n := range GetLabelNames(exportedLabelNames(m)...) {
+		if seen[n] {
+			return fmt.Errorf("exported metric '%s' of collector '%s' has label '%s' twice", m.ExportName, subsystem, n)
+		}
+		seen[n] = true
+	}
+	return nil
+}
+
+// toLabelValue renders a value returned by getValue, string or numeric, as a label value
+func toLabelValue(v interface{}) string {
+	defer trace()()
+	switch s := v.(type) {
+	case string:
+		return s
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
 func getType(m string) prometheus.ValueType {
 	defer trace()()
 	t := prometheus.GaugeValue
@@ -96,25 +324,56 @@ func getType(m string) prometheus.ValueType {
 	return t
 }
 
-func compute(t CollectableTemplate, m conf.MetricMap, e *govaluate.EvaluableExpression) float64 {
+// checkNumericSources fails if a metric exported as a number reads a string source, directly or in its ComputeLogic
+func checkNumericSources(subsystem string, m conf.MetricMap, e *govaluate.EvaluableExpression) error {
+	defer trace()()
+	if m.MetricType == "Info" || m.MetricType == "StateSet" {
+		return nil
+	}
+	var sources []string
+	if !m.ComputedMetric {
+		sources = m.SourceName[:1]
+	} else if e != nil {
+		sources = e.Vars()
+	}
+	for _, s := range sources {
+		if !NumericSource(subsystem, s) {
+			return fmt.Errorf("exported metric '%s' of collector '%s' reads string property '%s', only Info and StateSet metrics can", m.ExportName, subsystem, s)
+		}
+	}
+	return nil
+}
+
+// compute returns the value of a metric exported as a number. It fails rather than panicking the scrape when the value
+// is not a number, e.g. a string property of the source.
+func compute(t CollectableTemplate, m conf.MetricMap, e *govaluate.EvaluableExpression) (float64, error) {
 	defer trace()()
 	log.Debugf("compute: %s, %s", m.ExportName, m.ComputeLogic)
 	//process
 	if m.ComputedMetric {
 		log.Debugf("compute(%s, %s, %s)", m.ExportName, m.ComputeLogic, e)
+		if e == nil {
+			return 0, fmt.Errorf("invalid ComputeLogic (%s)", m.ComputeLogic)
+		}
 		params := make(map[string]interface{})
 		for _, k := range e.Vars() {
 			params[k] = t.getValue(k)
 		}
 		res, err := e.Evaluate(params)
 		if err != nil {
-			log.Errorf("Error computing from logic (%s). Error: %s", e, err)
-			return 0
+			return 0, fmt.Errorf("cannot compute from logic (%s): %s", e, err)
+		}
+		v, ok := res.(float64)
+		if !ok {
+			return 0, fmt.Errorf("logic (%s) computed %v, not a number", e, res)
 		}
-		return res.(float64)
+		return v, nil
 	}
-	return t.getValue(m.SourceName[0]).(float64)
-	//	conf.UCMConfig.Collectors.EnabledCollectors[subsystem].ExportedMetrics[]
+	v, ok := t.getValue(m.SourceName[0]).(float64)
+	if !ok {
+		return 0, fmt.Errorf("source %s is not a number", m.SourceName[0])
+	}
+	return v, nil
 }
 
 //ProcessVarName processes a varname to return the metric and its labels
@@ -125,7 +384,9 @@ func ProcessVarName(name string) (string, map[string]string) {
 	var v []string
 	for i := 1; i < len(n); i++ {
 		v = strings.Split(n[i], "@")
-		m[v[0]] = v[1]
+		if len(v) == 2 {
+			m[v[0]] = v[1]
+		}
 	}
 	return n[0], m
 }
@@ -135,4 +396,6 @@ type CollectableTemplate interface {
 	getValue(name string) interface{}
 	collect(m map[string]*prometheus.Desc, ch chan<- prometheus.Metric) (CollectableTemplate, error)
 	getMetricDesc(m map[string]*prometheus.Desc) error
+	// clone returns a template sharing no source values with this one, e.g. for background sampling
+	clone() CollectableTemplate
 }
@@ -0,0 +1,190 @@
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricName returns the fully-qualified name of a metric, which Desc only exposes through String
+func metricName(m prometheus.Metric) string {
+	desc := m.Desc().String()
+	name := desc[strings.Index(desc, `"`)+1:]
+	return name[:strings.Index(name, `"`)]
+}
+
+// collectTemplate builds a templated collector over a snapshot of source values, collects it the given number of times
+// and returns what the last collection sends, by metric name
+func collectTemplate(t *testing.T, spec conf.CollectorSpec, snapshot string, collections int) map[string][]*dto.Metric {
+	defer func(c conf.ConfigurationParameters) { conf.UCMConfig = c }(conf.UCMConfig)
+	conf.UCMConfig.Collectors.EnabledCollectors = map[string]conf.CollectorSpec{"test": spec}
+	s, err := NewSnapshotTemplate(strings.NewReader(snapshot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewTemplateCollector("test", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ch chan prometheus.Metric
+	for i := 0; i < collections; i++ {
+		ch = make(chan prometheus.Metric, 100)
+		if err := c.Collect(ch); err != nil {
+			t.Fatal(err)
+		}
+		close(ch)
+	}
+	metrics := map[string][]*dto.Metric{}
+	for m := range ch {
+		d := &dto.Metric{}
+		if err := m.Write(d); err != nil {
+			t.Fatal(err)
+		}
+		metrics[metricName(m)] = append(metrics[metricName(m)], d)
+	}
+	return metrics
+}
+
+// labels returns the labels of a metric as a map
+func labels(d *dto.Metric) map[string]string {
+	l := map[string]string{}
+	for _, p := range d.GetLabel() {
+		l[p.GetName()] = p.GetValue()
+	}
+	return l
+}
+
+func TestInfoAndStateSet(t *testing.T) {
+	metrics := collectTemplate(t, conf.CollectorSpec{ExportedMetrics: []conf.MetricMap{
+		{ExportName: "build_info", MetricType: "Info", SourceName: []string{"Version", "BuildNumber"}},
+		{ExportName: "state", MetricType: "StateSet", SourceName: []string{"State"}, States: []string{"running", "stopped"}},
+	}}, `{"Version": "10.0.17763", "BuildNumber": 17763, "State": "Running"}`, 1)
+
+	info := metrics["test_build_info"]
+	if len(info) != 1 || info[0].GetGauge().GetValue() != 1 {
+		t.Fatalf("expected a single info series of value 1, got %v", info)
+	}
+	if l := labels(info[0]); l["version"] != "10.0.17763" || l["buildnumber"] != "17763" {
+		t.Errorf("unexpected info labels %v", l)
+	}
+
+	states := map[string]float64{}
+	for _, d := range metrics["test_state"] {
+		states[labels(d)["state"]] = d.GetGauge().GetValue()
+	}
+	if len(states) != 2 || states["running"] != 1 || states["stopped"] != 0 {
+		t.Errorf("expected running to be the current state, got %v", states)
+	}
+}
+
+func TestStateSetWithoutSource(t *testing.T) {
+	defer func(c conf.ConfigurationParameters) { conf.UCMConfig = c }(conf.UCMConfig)
+	conf.UCMConfig.Collectors.EnabledCollectors = map[string]conf.CollectorSpec{"test": {ExportedMetrics: []conf.MetricMap{
+		{ExportName: "state", MetricType: "StateSet", States: []string{"running", "stopped"}},
+	}}}
+	s, _ := NewSnapshotTemplate(strings.NewReader(`{}`))
+	if _, err := NewTemplateCollector("test", s); err == nil {
+		t.Errorf("expected a StateSet without SourceName to be rejected")
+	}
+}
+
+func TestHistogramAndSummary(t *testing.T) {
+	metrics := collectTemplate(t, conf.CollectorSpec{ExportedMetrics: []conf.MetricMap{
+		{ExportName: "latency", MetricType: "Histogram", SourceName: []string{"Latency"}, Buckets: []float64{0.5, 1}},
+		{ExportName: "load", MetricType: "Summary", SourceName: []string{"Load"},
+			Objectives: []conf.Objective{{Quantile: 0.5, Error: 0.05}, {Quantile: 0.9, Error: 0.01}}},
+	}}, `{"Latency": 0.7, "Load": 3}`, 3)
+
+	latency := metrics["test_latency"]
+	if len(latency) != 1 {
+		t.Fatalf("expected a single histogram, got %v", latency)
+	}
+	h := latency[0].GetHistogram()
+	if h.GetSampleCount() != 3 || h.GetSampleSum() < 2.09 || h.GetSampleSum() > 2.11 {
+		t.Errorf("expected one observation per collection, got count %d sum %v", h.GetSampleCount(), h.GetSampleSum())
+	}
+	buckets := map[float64]uint64{}
+	for _, b := range h.GetBucket() {
+		buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+	}
+	if len(buckets) != 2 || buckets[0.5] != 0 || buckets[1] != 3 {
+		t.Errorf("expected the configured buckets, got %v", buckets)
+	}
+
+	load := metrics["test_load"]
+	if len(load) != 1 {
+		t.Fatalf("expected a single summary, got %v", load)
+	}
+	s := load[0].GetSummary()
+	quantiles := map[float64]float64{}
+	for _, q := range s.GetQuantile() {
+		quantiles[q.GetQuantile()] = q.GetValue()
+	}
+	if s.GetSampleCount() != 3 || s.GetSampleSum() != 9 || len(quantiles) != 2 || quantiles[0.5] != 3 || quantiles[0.9] != 3 {
+		t.Errorf("expected the configured objectives over 3 observations, got count %d sum %v quantiles %v", s.GetSampleCount(), s.GetSampleSum(), quantiles)
+	}
+}
+
+func TestStringSources(t *testing.T) {
+	// a snapshot does not know the types of its sources, so the value check happens during collection
+	metrics := collectTemplate(t, conf.CollectorSpec{ExportedMetrics: []conf.MetricMap{
+		{ExportName: "caption", SourceName: []string{"Caption"}},
+		{ExportName: "users", MetricType: "Counter", ComputedMetric: true, ComputeLogic: "NumberOfUsers + Caption"},
+		{ExportName: "processes", SourceName: []string{"NumberOfProcesses"}},
+	}}, `{"Caption": "Microsoft Windows Server 2019", "NumberOfProcesses": 42, "NumberOfUsers": 2}`, 1)
+	if _, ok := metrics["test_caption"]; ok {
+		t.Errorf("expected a string source to be skipped")
+	}
+	if _, ok := metrics["test_users"]; ok {
+		t.Errorf("expected logic computing a string to be skipped")
+	}
+	if p := metrics["test_processes"]; len(p) != 1 || p[0].GetGauge().GetValue() != 42 {
+		t.Errorf("expected numeric sources to be collected, got %v", p)
+	}
+
+	defer func(c conf.ConfigurationParameters) { conf.UCMConfig = c }(conf.UCMConfig)
+	s, _ := NewSnapshotTemplate(strings.NewReader(`{}`))
+	for _, m := range []conf.MetricMap{
+		{ExportName: "caption", MetricType: "Gauge", SourceName: []string{"Caption"}},
+		{ExportName: "caption", MetricType: "Summary", ComputedMetric: true, ComputeLogic: "Version * 2"},
+	} {
+		conf.UCMConfig.Collectors.EnabledCollectors = map[string]conf.CollectorSpec{"tos": {ExportedMetrics: []conf.MetricMap{m}}}
+		if _, err := NewTemplateCollector("tos", s); err == nil || !strings.Contains(err.Error(), "reads string property") {
+			t.Errorf("expected %+v to be rejected, got %v", m, err)
+		}
+	}
+	conf.UCMConfig.Collectors.EnabledCollectors = map[string]conf.CollectorSpec{"tos": {ExportedMetrics: []conf.MetricMap{
+		{ExportName: "info", MetricType: "Info", SourceName: []string{"Caption", "Version"}},
+		{ExportName: "users", SourceName: []string{"NumberOfUsers"}},
+	}}}
+	if _, err := NewTemplateCollector("tos", s); err != nil {
+		t.Errorf("expected numeric and Info metrics to be accepted, got %s", err)
+	}
+}
+
+func TestInfoLabelsPerInstance(t *testing.T) {
+	metrics := collectTemplate(t, conf.CollectorSpec{ExportedMetrics: []conf.MetricMap{
+		{ExportName: "services_info", MetricType: "Info", SourceName: []string{"State.name@spooler", "State.name@w32time", "StartMode.name@spooler"}},
+	}}, `{"State.name@spooler": "Running", "State.name@w32time": "Stopped", "StartMode.name@spooler": "Auto"}`, 1)
+	info := metrics["test_services_info"]
+	if len(info) != 1 {
+		t.Fatalf("expected a single info series, got %v", info)
+	}
+	expected := map[string]string{"state_name_spooler": "Running", "state_name_w32time": "Stopped", "startmode": "Auto"}
+	if l := labels(info[0]); !reflect.DeepEqual(l, expected) {
+		t.Errorf("expected labels %v, got %v", expected, l)
+	}
+
+	defer func(c conf.ConfigurationParameters) { conf.UCMConfig = c }(conf.UCMConfig)
+	conf.UCMConfig.Collectors.EnabledCollectors = map[string]conf.CollectorSpec{"test": {ExportedMetrics: []conf.MetricMap{
+		{ExportName: "info", MetricType: "Info", SourceName: []string{"Version", "Version"}},
+	}}}
+	s, _ := NewSnapshotTemplate(strings.NewReader(`{}`))
+	if _, err := NewTemplateCollector("test", s); err == nil || !strings.Contains(err.Error(), "label 'version' twice") {
+		t.Errorf("expected duplicate labels to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ResolvedVar is a variable of an expression, split by ProcessVarName and resolved against the source values of a template
+type ResolvedVar struct {
+	Name   string
+	Metric string
+	Labels map[string]string
+	Value  interface{}
+}
+
+// ExpressionResult holds the outcome of an expression along with every variable it used
+type ExpressionResult struct {
+	Value interface{}
+	Vars  []ResolvedVar
+}
+
+// EvaluateExpression evaluates a ComputeLogic expression once against the source values of a template
+func EvaluateExpression(expression string, t CollectableTemplate) (*ExpressionResult, error) {
+	defer trace()()
+	e, err := newSmoothingHistory().parse(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ExpressionResult{}
+	params := make(map[string]interface{})
+	for _, k := range e.Vars() {
+		metric, labels := ProcessVarName(k)
+		params[k] = t.getValue(k)
+		res.Vars = append(res.Vars, ResolvedVar{Name: k, Metric: metric, Labels: labels, Value: params[k]})
+	}
+	res.Value, err = e.Evaluate(params)
+	if err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// MetricExpression returns the expression of a configured metric: its ComputeLogic, or its source value for a metric
+// that is not computed. Source names are bracketed, as govaluate cannot parse name.label@value otherwise.
+func MetricExpression(m conf.MetricMap) string {
+	defer trace()()
+	if !m.ComputedMetric && len(m.SourceName) > 0 {
+		return "[" + m.SourceName[0] + "]"
+	}
+	return m.ComputeLogic
+}
+
+// ValidateExpression parses a ComputeLogic expression with the same function table used during collection
+func ValidateExpression(expression string) error {
+	defer trace()()
+	_, err := newSmoothingHistory().parse(expression)
+	return err
+}
+
+// snapshotTemplate serves source values recorded as JSON instead of querying WMI. Keys are either full variable
+// names, e.g. time_total.mode@idle, or bare metric names; arrays stand for one value per instance.
+type snapshotTemplate map[string]interface{}
+
+// NewSnapshotTemplate reads a JSON object of recorded source values
+func NewSnapshotTemplate(r io.Reader) (CollectableTemplate, error) {
+	defer trace()()
+	s := snapshotTemplate{}
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s snapshotTemplate) getValue(name string) interface{} {
+	defer trace()()
+	if v, ok := s[name]; ok {
+		return v
+	}
+	metric, _ := ProcessVarName(name)
+	if v, ok := s[metric]; ok {
+		return v
+	}
+	return 0.
+}
+
+func (s snapshotTemplate) collect(m map[string]*prometheus.Desc, ch chan<- prometheus.Metric) (CollectableTemplate, error) {
+	defer trace()()
+	return s, nil
+}
+
+// clone returns the snapshot itself, as it is never changed
+func (s snapshotTemplate) clone() CollectableTemplate {
+	defer trace()()
+	return s
+}
+
+func (s snapshotTemplate) getMetricDesc(m map[string]*prometheus.Desc) error {
+	defer trace()()
+	return nil
+}
+
+// LiveTemplate collects the current source values of a templated collector
+func LiveTemplate(subsystem string) (CollectableTemplate, error) {
+	defer trace()()
+	fn, ok := Factories[subsystem]
+	if !ok {
+		return nil, fmt.Errorf("collector '%s' not available", subsystem)
+	}
+	c, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	tc, ok := c.(*TemplateCollector)
+	if !ok {
+		return nil, fmt.Errorf("collector '%s' is not a templated collector", subsystem)
+	}
+	tc.Stop()
+	return tc.collectSource()
+}
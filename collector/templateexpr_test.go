@@ -0,0 +1,33 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+func TestMetricExpressionSnapshot(t *testing.T) {
+	snapshot, err := NewSnapshotTemplate(strings.NewReader(`{"time_total.mode@idle": 42, "time_total.mode@user": 8}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []conf.MetricMap{
+		{ExportName: "idle", SourceName: []string{"time_total.mode@idle"}},
+		{ExportName: "idle_pct", ComputedMetric: true,
+			ComputeLogic: "[time_total.mode@idle] * 100 / ([time_total.mode@idle] + [time_total.mode@user])"},
+	} {
+		res, err := EvaluateExpression(MetricExpression(m), snapshot)
+		if err != nil {
+			t.Errorf("%s: %s", m.ExportName, err)
+			continue
+		}
+		if res.Vars[0].Metric != "time_total" || res.Vars[0].Labels["mode"] != "idle" {
+			t.Errorf("%s: unexpected variable %+v", m.ExportName, res.Vars[0])
+		}
+	}
+	res, _ := EvaluateExpression(MetricExpression(conf.MetricMap{SourceName: []string{"time_total.mode@idle"}}), snapshot)
+	if res == nil || res.Value != 42. {
+		t.Errorf("expected the source value 42, got %+v", res)
+	}
+}
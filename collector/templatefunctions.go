@@ -1,7 +1,9 @@
 package collector
 
 import (
+	"fmt"
 	"math"
+	"reflect"
 
 	"github.com/Knetic/govaluate"
 )
@@ -84,3 +86,130 @@ func min(args ...interface{}) (interface{}, error) {
 	}
 	return s, nil
 }
+
+// smoothingHistory keeps the state of the ewma and avg_over calls of one expression between collections. Calls are
+// told apart by their position in the expression text, so that calls skipped in an evaluation, e.g. in a branch of a
+// ternary operator, keep their own state.
+type smoothingHistory struct {
+	ewma    map[int]float64
+	windows map[int][]float64
+}
+
+func newSmoothingHistory() *smoothingHistory {
+	defer trace()()
+	return &smoothingHistory{
+		ewma:    make(map[int]float64),
+		windows: make(map[int][]float64),
+	}
+}
+
+// ewmaCall and avgOverCall stand for the smoothing functions while an expression is parsed, until parse binds every
+// call to its own state
+func ewmaCall(args ...interface{}) (interface{}, error) {
+	return nil, fmt.Errorf("ewma is not bound to a history")
+}
+
+func avgOverCall(args ...interface{}) (interface{}, error) {
+	return nil, fmt.Errorf("avg_over is not bound to a history")
+}
+
+// parse parses an expression with the shared function table and the smoothing functions, binding each ewma and
+// avg_over call to the history under its position among the calls of the expression text
+func (h *smoothingHistory) parse(expression string) (*govaluate.EvaluableExpression, error) {
+	defer trace()()
+	f := make(map[string]govaluate.ExpressionFunction, len(functions)+2)
+	for k, v := range functions {
+		f[k] = v
+	}
+	f["ewma"] = ewmaCall
+	f["avg_over"] = avgOverCall
+	e, err := govaluate.NewEvaluableExpressionWithFunctions(expression, f)
+	if err != nil {
+		return nil, err
+	}
+
+	// tokens are in the order of the text, functions can only be told apart by their code
+	ewma, avgOver := reflect.ValueOf(ewmaCall).Pointer(), reflect.ValueOf(avgOverCall).Pointer()
+	tokens := e.Tokens()
+	bound := false
+	for i, t := range tokens {
+		if t.Kind != govaluate.FUNCTION {
+			continue
+		}
+		switch reflect.ValueOf(t.Value).Pointer() {
+		case ewma:
+			tokens[i].Value = govaluate.ExpressionFunction(h.ewmaFunc(i))
+			bound = true
+		case avgOver:
+			tokens[i].Value = govaluate.ExpressionFunction(h.avgOverFunc(i))
+			bound = true
+		}
+	}
+	if !bound {
+		return e, nil
+	}
+	return govaluate.NewEvaluableExpressionFromTokens(tokens)
+}
+
+// reset drops all history, e.g. when the source series disappeared
+func (h *smoothingHistory) reset() {
+	defer trace()()
+	h.ewma = make(map[int]float64)
+	h.windows = make(map[int][]float64)
+}
+
+// ewmaFunc returns the ewma function of the call at position call
+func (h *smoothingHistory) ewmaFunc(call int) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		defer trace()()
+		if len(args) != 2 {
+			return nil, fmt.Errorf("ewma expects 2 arguments (value, alpha), got %d", len(args))
+		}
+		x, ok := args[0].(float64)
+		if !ok {
+			delete(h.ewma, call)
+			return nil, fmt.Errorf("ewma expects a single numeric value, got %v", args[0])
+		}
+		alpha, ok := args[1].(float64)
+		if !ok || alpha <= 0 || alpha > 1 {
+			return nil, fmt.Errorf("ewma expects an alpha in (0, 1], got %v", args[1])
+		}
+		s, ok := h.ewma[call]
+		if !ok {
+			s = x
+		} else {
+			s = alpha*x + (1-alpha)*s
+		}
+		h.ewma[call] = s
+		return s, nil
+	}
+}
+
+// avgOverFunc returns the avg_over function of the call at position call
+func (h *smoothingHistory) avgOverFunc(call int) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		defer trace()()
+		if len(args) != 2 {
+			return nil, fmt.Errorf("avg_over expects 2 arguments (value, N), got %d", len(args))
+		}
+		x, ok := args[0].(float64)
+		if !ok {
+			delete(h.windows, call)
+			return nil, fmt.Errorf("avg_over expects a single numeric value, got %v", args[0])
+		}
+		n, ok := args[1].(float64)
+		if !ok || n < 1 {
+			return nil, fmt.Errorf("avg_over expects a window of at least 1, got %v", args[1])
+		}
+		w := append(h.windows[call], x)
+		if len(w) > int(n) {
+			w = w[len(w)-int(n):]
+		}
+		h.windows[call] = w
+		s := 0.
+		for _, v := range w {
+			s += v
+		}
+		return s / float64(len(w)), nil
+	}
+}
@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"math"
+	"testing"
+)
+
+// evaluate runs an expression using the history over successive values of x, the way collections do
+func evaluate(t *testing.T, h *smoothingHistory, expression string, xs ...float64) []float64 {
+	e, err := h.parse(expression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []float64
+	for _, x := range xs {
+		v, err := e.Evaluate(map[string]interface{}{"x": x})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, v.(float64))
+	}
+	return out
+}
+
+func TestEWMAConverges(t *testing.T) {
+	h := newSmoothingHistory()
+	xs := []float64{0}
+	for i := 0; i < 50; i++ {
+		xs = append(xs, 10)
+	}
+	out := evaluate(t, h, "ewma(x, 0.5)", xs...)
+	if out[0] != 0 || out[1] != 5 || out[2] != 7.5 {
+		t.Errorf("expected the first value to seed the average, got %v", out[:3])
+	}
+	for i := 2; i < len(out); i++ {
+		if out[i] < out[i-1] || out[i] > 10 {
+			t.Fatalf("expected a monotonic approach to 10, got %v", out)
+		}
+	}
+	if last := out[len(out)-1]; math.Abs(last-10) > 1e-9 {
+		t.Errorf("expected ewma to converge to 10, got %v", last)
+	}
+
+	// two calls in one expression keep separate state
+	out = evaluate(t, newSmoothingHistory(), "ewma(x, 1) - ewma(x, 0.5)", 0, 10)
+	if out[1] != 5 {
+		t.Errorf("expected independent calls, got %v", out)
+	}
+	// a call skipped in one evaluation keeps its state apart from the calls after it
+	out = evaluate(t, newSmoothingHistory(), "x > 5 ? ewma(x, 0.5) : ewma(x, 1)", 0, 10)
+	if out[1] != 10 {
+		t.Errorf("expected the first call to start from its own first value, got %v", out)
+	}
+}
+
+func TestAvgOverWindow(t *testing.T) {
+	h := newSmoothingHistory()
+	out := evaluate(t, h, "avg_over(x, 3)", 3, 6, 9, 12, 15)
+	expected := []float64{3, 4.5, 6, 9, 12}
+	for i := range expected {
+		if out[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, out)
+			break
+		}
+	}
+
+	xs := make([]float64, 10000)
+	for i := range xs {
+		xs[i] = 7
+	}
+	if out = evaluate(t, h, "avg_over(x, 3)", xs...); out[len(out)-1] != 7 {
+		t.Errorf("expected avg_over to converge to 7, got %v", out[len(out)-1])
+	}
+	if w := h.windows[0]; len(w) != 3 || cap(w) > 16 {
+		t.Errorf("expected the window to stay bounded, got len %d cap %d", len(w), cap(w))
+	}
+}
+
+func TestSmoothingReset(t *testing.T) {
+	h := newSmoothingHistory()
+	evaluate(t, h, "ewma(x, 0.5) + avg_over(x, 4)", 100, 100, 100)
+	h.reset()
+	if len(h.ewma) != 0 || len(h.windows) != 0 {
+		t.Fatalf("expected reset to drop all history, got %v and %v", h.ewma, h.windows)
+	}
+	if out := evaluate(t, h, "ewma(x, 0.5) + avg_over(x, 4)", 2); out[0] != 4 {
+		t.Errorf("expected the history to start over from the new value, got %v", out)
+	}
+}
@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/djonnala/wmi_exporter/log"
+)
+
+// A sampleWindow aggregates the samples of one metric taken since the previous scrape. It only keeps running
+// aggregates, so memory use does not grow with the sampling rate.
+type sampleWindow struct {
+	min, max, sum, last float64
+	count               int
+	sampled             bool
+
+	minDesc, maxDesc, avgDesc, lastDesc *prometheus.Desc
+}
+
+func newSampleWindow(namespace, subsystem string, m conf.MetricMap) *sampleWindow {
+	defer trace()()
+	desc := func(suffix, help string) *prometheus.Desc {
+		return newDesc(
+			prometheus.BuildFQName(namespace, subsystem, m.ExportName+suffix),
+			help+" of "+m.ExportName+" since the previous scrape - "+m.Desc,
+			GetLabelNames(),
+			nil,
+		)
+	}
+	return &sampleWindow{
+		minDesc:  desc("_min", "Minimum sampled value"),
+		maxDesc:  desc("_max", "Maximum sampled value"),
+		avgDesc:  desc("_avg", "Average sampled value"),
+		lastDesc: desc("_last", "Last sampled value"),
+	}
+}
+
+func (w *sampleWindow) add(v float64) {
+	if w.count == 0 || v < w.min {
+		w.min = v
+	}
+	if w.count == 0 || v > w.max {
+		w.max = v
+	}
+	w.sum += v
+	w.last = v
+	w.count++
+	w.sampled = true
+}
+
+// collect sends the aggregates of the current window and starts a new one
+func (w *sampleWindow) collect(ch chan<- prometheus.Metric) {
+	if w.count > 0 {
+		ch <- prometheus.MustNewConstMetric(w.minDesc, prometheus.GaugeValue, w.min, GetLabelValues()...)
+		ch <- prometheus.MustNewConstMetric(w.maxDesc, prometheus.GaugeValue, w.max, GetLabelValues()...)
+		ch <- prometheus.MustNewConstMetric(w.avgDesc, prometheus.GaugeValue, w.sum/float64(w.count), GetLabelValues()...)
+	}
+	if w.sampled {
+		ch <- prometheus.MustNewConstMetric(w.lastDesc, prometheus.GaugeValue, w.last, GetLabelValues()...)
+	}
+	w.min, w.max, w.sum, w.count = 0, 0, 0, 0
+}
+
+// sample runs in the background and feeds every sampled metric at the configured interval until the collector is stopped
+func (c *TemplateCollector) sample(interval time.Duration, stopCh <-chan struct{}) {
+	defer trace()()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.sampleOnce(); err != nil {
+				log.Errorf("Error sampling templated collector. Error=%s", err)
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (c *TemplateCollector) sampleOnce() error {
+	defer trace()()
+	// query before locking, so that scrapes do not wait on WMI, and only fold the values in under the lock
+	ct, err := c.collectSource()
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if err != nil {
+		c.resetHistory()
+		return err
+	}
+
+	for _, v := range c.metricMapList {
+		if w, ok := c.sampleList[v.ExportName]; ok {
+			val, err := compute(ct, v, c.metricExprList[v.ExportName])
+			if err != nil {
+				log.Errorf("Error sampling metric %s. Error=%s", v.ExportName, err)
+				continue
+			}
+			w.add(val)
+		}
+	}
+	return nil
+}
+
+// collectSource refreshes the source values of the sampled template without emitting any metrics
+func (c *TemplateCollector) collectSource() (CollectableTemplate, error) {
+	defer trace()()
+	// built-in metrics are only emitted for descriptors in the map, so an empty map collects the source values alone
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	ct, err := c.sampleObj.collect(map[string]*prometheus.Desc{}, ch)
+	close(ch)
+	<-done
+	return ct, err
+}
+
+// Stop ends background sampling. It is safe to call more than once.
+func (c *TemplateCollector) Stop() {
+	defer trace()()
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.stopCh != nil {
+		close(c.stopCh)
+		c.stopCh = nil
+	}
+}
@@ -0,0 +1,147 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// drain collects the metrics a window sends, by name
+func drain(t *testing.T, w *sampleWindow) map[string]float64 {
+	ch := make(chan prometheus.Metric, 4)
+	w.collect(ch)
+	close(ch)
+	values := map[string]float64{}
+	for m := range ch {
+		var d dto.Metric
+		if err := m.Write(&d); err != nil {
+			t.Fatal(err)
+		}
+		values[metricName(m)] = d.GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestSampleWindow(t *testing.T) {
+	w := newSampleWindow("wmi", "test", conf.MetricMap{ExportName: "queue"})
+	for _, v := range []float64{3, 1, 2} {
+		w.add(v)
+	}
+	expected := map[string]float64{"wmi_test_queue_min": 1, "wmi_test_queue_max": 3, "wmi_test_queue_avg": 2, "wmi_test_queue_last": 2}
+	got := drain(t, w)
+	for name, v := range expected {
+		if got[name] != v {
+			t.Errorf("expected %s to be %v, got %v", name, v, got[name])
+		}
+	}
+
+	// a window without samples only repeats the last value
+	if got := drain(t, w); len(got) != 1 || got["wmi_test_queue_last"] != 2 {
+		t.Errorf("expected only the last value from an empty window, got %v", got)
+	}
+
+	// running aggregates only, however many samples are taken
+	if allocs := testing.AllocsPerRun(10000, func() { w.add(5) }); allocs != 0 {
+		t.Errorf("expected sampling not to allocate, got %v allocations per sample", allocs)
+	}
+	if got := drain(t, w); got["wmi_test_queue_min"] != 5 || got["wmi_test_queue_avg"] != 5 {
+		t.Errorf("unexpected aggregates %v", got)
+	}
+}
+
+func TestTemplateCollectorStop(t *testing.T) {
+	saved := conf.UCMConfig
+	defer func() { conf.UCMConfig = saved }()
+	conf.UCMConfig.Collectors.EnabledCollectors = map[string]conf.CollectorSpec{
+		"sampled": {SampleInterval: 1, ExportedMetrics: []conf.MetricMap{{SourceName: []string{"queue"}, ExportName: "queue"}}},
+	}
+	snapshot, _ := NewSnapshotTemplate(strings.NewReader(`{"queue": 4}`))
+	coll, err := NewTemplateCollector("sampled", snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := coll.(*TemplateCollector)
+	c.Stop()
+
+	// sample again, quickly, and check that Stop ends the goroutine
+	stopCh := make(chan struct{})
+	c.stopCh = stopCh
+	done := make(chan struct{})
+	go func() {
+		c.sample(time.Millisecond, stopCh)
+		close(done)
+	}()
+	waitFor := time.After(5 * time.Second)
+	for {
+		c.mtx.Lock()
+		sampled := c.sampleList["queue"].count
+		c.mtx.Unlock()
+		if sampled >= 3 {
+			break
+		}
+		select {
+		case <-waitFor:
+			t.Fatalf("timed out waiting for samples")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	c.Stop()
+	c.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Stop to end sampling")
+	}
+}
+
+// blockingTemplate is a snapshot whose clones block in collect until released, like a slow WMI query
+type blockingTemplate struct {
+	snapshotTemplate
+	entered, release chan struct{}
+}
+
+func (b blockingTemplate) clone() CollectableTemplate {
+	return b
+}
+
+func (b blockingTemplate) collect(m map[string]*prometheus.Desc, ch chan<- prometheus.Metric) (CollectableTemplate, error) {
+	close(b.entered)
+	<-b.release
+	return b.snapshotTemplate, nil
+}
+
+func TestSampleOnceQueriesUnlocked(t *testing.T) {
+	saved := conf.UCMConfig
+	defer func() { conf.UCMConfig = saved }()
+	conf.UCMConfig.Collectors.EnabledCollectors = map[string]conf.CollectorSpec{
+		"sampled": {SampleInterval: 3600, ExportedMetrics: []conf.MetricMap{{SourceName: []string{"queue"}, ExportName: "queue"}}},
+	}
+	coll, err := NewTemplateCollector("sampled", snapshotTemplate{"queue": 4.})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := coll.(*TemplateCollector)
+	defer c.Stop()
+	entered, release := make(chan struct{}), make(chan struct{})
+	c.sampleObj = blockingTemplate{snapshotTemplate{"queue": 4.}, entered, release}
+
+	sampled := make(chan error)
+	go func() { sampled <- c.sampleOnce() }()
+	// scrapes can take the lock while the sampler waits on its query
+	<-entered
+	c.mtx.Lock()
+	c.mtx.Unlock()
+	close(release)
+	if err := <-sampled; err != nil {
+		t.Fatal(err)
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if w := c.sampleList["queue"]; w.count != 1 || w.last != 4 {
+		t.Errorf("expected the sample to be folded in, got %+v", w)
+	}
+}
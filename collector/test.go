@@ -138,45 +138,50 @@ func (c *testMetrics) collect(m map[string]*prometheus.Desc, ch chan<- prometheu
 	return &t, nil
 }
 
+func (c *testMetrics) clone() CollectableTemplate {
+	defer trace()()
+	return &testMetrics{}
+}
+
 func (c *testMetrics) getMetricDesc(m map[string]*prometheus.Desc) error {
 	defer trace()()
-	m["Test1"] = prometheus.NewDesc(
+	m["Test1"] = newDesc(
 		prometheus.BuildFQName(Namespace, testSubsystem, "test1"),
 		"Test Metric number 1",
 		GetLabelNames("core", "state"),
 		nil,
 	)
-	m["Test2"] = prometheus.NewDesc(
+	m["Test2"] = newDesc(
 		prometheus.BuildFQName(Namespace, testSubsystem, "test2"),
 		"Test Metric number 2",
 		GetLabelNames("core", "state"),
 		nil,
 	)
-	m["Test3"] = prometheus.NewDesc(
+	m["Test3"] = newDesc(
 		prometheus.BuildFQName(Namespace, testSubsystem, "test3"),
 		"Test Metric number 3",
 		GetLabelNames("core", "state"),
 		nil,
 	)
-	m["Test4"] = prometheus.NewDesc(
+	m["Test4"] = newDesc(
 		prometheus.BuildFQName(Namespace, testSubsystem, "test4"),
 		"Test Metric number 4",
 		GetLabelNames("core", "state"),
 		nil,
 	)
-	m["Test5"] = prometheus.NewDesc(
+	m["Test5"] = newDesc(
 		prometheus.BuildFQName(Namespace, testSubsystem, "test5"),
 		"Test Metric number 5",
 		GetLabelNames("core", "state"),
 		nil,
 	)
-	m["Test6"] = prometheus.NewDesc(
+	m["Test6"] = newDesc(
 		prometheus.BuildFQName(Namespace, testSubsystem, "test6"),
 		"Test Metric number 6",
 		GetLabelNames("core", "state"),
 		nil,
 	)
-	m["Test7"] = prometheus.NewDesc(
+	m["Test7"] = newDesc(
 		prometheus.BuildFQName(Namespace, testSubsystem, "test7"),
 		"Test Metric number 7",
 		GetLabelNames("core", "state"),
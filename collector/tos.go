@@ -0,0 +1,105 @@
+//go:build windows
+// +build windows
+
+// returns inventory data points from Win32_OperatingSystem for use with templated metrics
+// https://msdn.microsoft.com/en-us/library/aa394239 - Win32_OperatingSystem class
+package collector
+
+import (
+	"github.com/StackExchange/wmi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const tosSubsystem = "tos"
+
+func init() {
+	defer trace()()
+	Factories[tosSubsystem] = osTemplateCollector
+}
+
+type win32OperatingSystemInventory struct {
+	Caption                string
+	Version                string
+	BuildNumber            string
+	OSArchitecture         string
+	CSDVersion             string
+	NumberOfProcesses      uint32
+	NumberOfUsers          uint32
+	FreePhysicalMemory     uint64
+	TotalVisibleMemorySize uint64
+}
+
+// A tOSCollector is a templated collector for WMI Win32_OperatingSystem properties, including string properties
+type tOSCollector struct {
+	data win32OperatingSystemInventory
+}
+
+// osTemplateCollector returns the templated Win32_OperatingSystem collector
+func osTemplateCollector() (Collector, error) {
+	defer trace()()
+	return NewTemplateCollector(tosSubsystem, &tOSCollector{})
+}
+
+func (c *tOSCollector) getValue(varname string) interface{} {
+	defer trace()()
+	name, _ := ProcessVarName(varname)
+	switch name {
+	case "Caption":
+		return c.data.Caption
+	case "Version":
+		return c.data.Version
+	case "BuildNumber":
+		return c.data.BuildNumber
+	case "OSArchitecture":
+		return c.data.OSArchitecture
+	case "CSDVersion":
+		return c.data.CSDVersion
+	case "NumberOfProcesses":
+		return float64(c.data.NumberOfProcesses)
+	case "NumberOfUsers":
+		return float64(c.data.NumberOfUsers)
+	case "FreePhysicalMemory":
+		return float64(c.data.FreePhysicalMemory * 1024) // KiB -> bytes
+	case "TotalVisibleMemorySize":
+		return float64(c.data.TotalVisibleMemorySize * 1024) // KiB -> bytes
+	}
+	return 0.
+}
+
+func (c *tOSCollector) clone() CollectableTemplate {
+	defer trace()()
+	return &tOSCollector{}
+}
+
+func (c *tOSCollector) getMetricDesc(m map[string]*prometheus.Desc) error {
+	defer trace()()
+	m["info"] = newDesc(
+		prometheus.BuildFQName(Namespace, tosSubsystem, "info"),
+		"OperatingSystem.Caption, Version and BuildNumber",
+		GetLabelNames("caption", "version", "build_number"),
+		nil,
+	)
+	return nil
+}
+
+func (c *tOSCollector) collect(m map[string]*prometheus.Desc, ch chan<- prometheus.Metric) (CollectableTemplate, error) {
+	defer trace()()
+	var dst []win32OperatingSystemInventory
+	// the struct name differs from the WMI class name, already taken by the os collector, so the query names the class
+	if err := wmi.Query("SELECT * FROM Win32_OperatingSystem", &dst); err != nil {
+		return nil, err
+	}
+	if len(dst) > 0 {
+		c.data = dst[0]
+	}
+
+	if _, ok := m["info"]; ok {
+		ch <- prometheus.MustNewConstMetric(
+			m["info"],
+			prometheus.GaugeValue,
+			1,
+			GetLabelValues(c.data.Caption, c.data.Version, c.data.BuildNumber)...,
+		)
+	}
+	return c, nil
+}
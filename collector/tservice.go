@@ -0,0 +1,79 @@
+//go:build windows
+// +build windows
+
+// returns data points from Win32_Service for use with templated metrics
+// https://msdn.microsoft.com/en-us/library/aa394418(v=vs.85).aspx - Win32_Service class
+package collector
+
+import (
+	"strings"
+
+	"github.com/StackExchange/wmi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const tserviceSubsystem = "tservice"
+
+func init() {
+	defer trace()()
+	Factories[tserviceSubsystem] = serviceTemplateCollector
+}
+
+// A tServiceCollector is a templated collector for WMI Win32_Service properties. Variables select a service by
+// name, e.g. StartMode.name@wuauserv
+type tServiceCollector struct {
+	lmap       map[string]Win32_Service
+	nameFilter *instanceFilter
+}
+
+// serviceTemplateCollector returns the templated Win32_Service collector
+func serviceTemplateCollector() (Collector, error) {
+	defer trace()()
+	filter, err := newInstanceFilter(tserviceSubsystem, ".+", "")
+	if err != nil {
+		return nil, err
+	}
+	return NewTemplateCollector(tserviceSubsystem, &tServiceCollector{nameFilter: filter})
+}
+
+func (c *tServiceCollector) getValue(varname string) interface{} {
+	defer trace()()
+	name, m := ProcessVarName(varname)
+	service := c.lmap[strings.ToLower(m["name"])]
+	switch name {
+	case "Name":
+		return service.Name
+	case "State":
+		return service.State
+	case "StartMode":
+		return service.StartMode
+	}
+	return 0.
+}
+
+func (c *tServiceCollector) clone() CollectableTemplate {
+	defer trace()()
+	return &tServiceCollector{nameFilter: c.nameFilter}
+}
+
+func (c *tServiceCollector) getMetricDesc(m map[string]*prometheus.Desc) error {
+	defer trace()()
+	return nil
+}
+
+func (c *tServiceCollector) collect(m map[string]*prometheus.Desc, ch chan<- prometheus.Metric) (CollectableTemplate, error) {
+	defer trace()()
+	var dst []Win32_Service
+	q := wmi.CreateQuery(&dst, "")
+	if err := wmi.Query(q, &dst); err != nil {
+		return nil, err
+	}
+
+	c.lmap = make(map[string]Win32_Service, len(dst))
+	for _, service := range dst {
+		if c.nameFilter.matches(strings.ToLower(service.Name)) {
+			c.lmap[strings.ToLower(service.Name)] = service
+		}
+	}
+	return c, nil
+}
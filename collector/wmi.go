@@ -15,8 +15,34 @@ const (
 // Factories ...
 var Factories = make(map[string]func() (Collector, error))
 
+// Known lists every collector, including those left out of builds for other platforms, so that configurations can be
+// checked anywhere
+var Known = []string{"cpu", "cs", "dns", "iis", "logical_disk", "net", "os", "service", "system", "tcpu", "test", "tos", "tservice"}
+
+// stringSources lists the source properties of templated collectors holding strings, which only Info and StateSet
+// metrics can export. It is kept here rather than with the collectors so that configurations can be checked anywhere.
+var stringSources = map[string]map[string]bool{
+	"tos":      {"Caption": true, "Version": true, "BuildNumber": true, "OSArchitecture": true, "CSDVersion": true},
+	"tservice": {"Name": true, "State": true, "StartMode": true},
+}
+
+// NumericSource tells whether a source of a templated collector, e.g. State.name@spooler, holds a number
+func NumericSource(collector string, source string) bool {
+	defer trace()()
+	name, _ := ProcessVarName(source)
+	return !stringSources[collector][name]
+}
+
 // Collector is the interface a collector has to implement.
 type Collector interface {
 	// Get new metrics and expose them via prometheus registry.
 	Collect(ch chan<- prometheus.Metric) (err error)
+	// Describe returns what the descriptors of the metrics the collector exposes are built from, so that their labels
+	// can be checked without a scrape
+	Describe() []MetricDesc
+}
+
+// Stopper is implemented by collectors running background work that has to end when the collector is disabled.
+type Stopper interface {
+	Stop()
 }
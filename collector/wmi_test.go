@@ -0,0 +1,15 @@
+package collector
+
+import "testing"
+
+func TestKnownCollectors(t *testing.T) {
+	known := map[string]bool{}
+	for _, k := range Known {
+		known[k] = true
+	}
+	for name := range Factories {
+		if !known[name] {
+			t.Errorf("collector '%s' is missing from Known", name)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Problem is a configuration issue found by CheckConfig, located in the configuration file where possible
+type Problem struct {
+	File    string
+	Line    int
+	Message string
+
+	// key is the dotted path the problem is about, used to find its line
+	key string
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Message)
+}
+
+// metricTypes lists the accepted values of MetricMap.MetricType, where empty means Gauge
+var metricTypes = map[string]bool{
+	"":          true,
+	"Gauge":     true,
+	"Counter":   true,
+	"Histogram": true,
+	"Summary":   true,
+	"Info":      true,
+	"StateSet":  true,
+}
+
+// CheckConfig decodes a configuration file and its fragments strictly and reports every problem found in them. include
+// replaces the Include pattern of the file when set. Checks that need knowledge from other packages are handed in:
+// collectorAvailable tells whether a collector exists, validateExpression parses a ComputeLogic expression and
+// numericSource tells whether a source of a collector holds numbers.
+func CheckConfig(configfile string, include string, collectorAvailable func(name string) bool, validateExpression func(expr string) error, numericSource func(collector string, source string) bool) []Problem {
+	defer trace()()
+	c, meta, problems := checkFile(configfile, mainFormat(configfile), collectorAvailable, validateExpression, numericSource)
+	if meta == nil {
+		return problems
+	}
+	origin := make(map[string]string)
+	for k := range meta.defined {
+		origin[k] = "file " + configfile
+	}
+
+	if include == "" {
+		include = c.Include
+	}
+	files, err := fragmentFiles(configfile, include)
+	if err != nil {
+		return append(problems, Problem{File: configfile, Message: err.Error()})
+	}
+	for _, f := range files {
+		frag, fmeta, fproblems := checkFile(f, formatOf(f), collectorAvailable, validateExpression, numericSource)
+		problems = append(problems, fproblems...)
+		if fmeta == nil {
+			continue
+		}
+		data, _ := ioutil.ReadFile(f)
+		pos := newLocator(formatOf(f), data)
+		for _, p := range mergeFragment(&c, frag, *fmeta, f, origin) {
+			p.Line = pos.line(p.key)
+			problems = append(problems, p)
+		}
+	}
+
+	if err := ResolveSecrets(&c); err != nil {
+		problems = append(problems, Problem{File: configfile, Message: err.Error()})
+	}
+	return append(problems, checkService(configfile, c)...)
+}
+
+// checkFile reports the problems of a single configuration file in the given format. The metadata is nil if the file
+// cannot be decoded.
+func checkFile(configfile string, format string, collectorAvailable func(name string) bool, validateExpression func(expr string) error, numericSource func(collector string, source string) bool) (ConfigurationParameters, *fileMeta, []Problem) {
+	defer trace()()
+	var c ConfigurationParameters
+	meta, data, err := decodeFile(configfile, format, &c)
+	if err != nil {
+		p := Problem{File: configfile, Message: err.Error()}
+		if e, ok := err.(*DecodeError); ok {
+			p.Message, p.Line = e.Err.Error(), e.Line
+		}
+		return c, nil, []Problem{p}
+	}
+
+	pos := newLocator(format, data)
+	var problems []Problem
+	report := func(key string, format string, args ...interface{}) {
+		problems = append(problems, Problem{File: configfile, Line: pos.line(key), Message: fmt.Sprintf(format, args...), key: key})
+	}
+
+	sort.Strings(meta.undecoded)
+	for _, k := range meta.undecoded {
+		report(k, "unknown key %s", k)
+	}
+
+	checkCollectors(c, collectorAvailable, validateExpression, numericSource, report)
+
+	labelNames := make([]string, 0, len(c.ExternalLabels))
+	for k := range c.ExternalLabels {
+		labelNames = append(labelNames, k)
+	}
+	sort.Strings(labelNames)
+	for _, k := range labelNames {
+		if err := checkLabelName(k); err != nil {
+			report("ExternalLabels."+k, "%s", err)
+		}
+	}
+	if c.ExternalLabelsFile != "" {
+		if _, err := readLabelsFile(c.ExternalLabelsFile); err != nil {
+			report("ExternalLabelsFile", "%s", err)
+		}
+	}
+
+	for i, r := range c.MetricRelabelConfigs {
+		if err := r.Validate(); err != nil {
+			report(fmt.Sprintf("MetricRelabelConfigs#%d", i), "metric relabel config #%d: %s", i+1, err)
+		}
+	}
+
+	return c, &meta, problems
+}
+
+// checkCollectors reports the problems of the collector specs of a configuration
+func checkCollectors(c ConfigurationParameters, collectorAvailable func(name string) bool, validateExpression func(expr string) error, numericSource func(collector string, source string) bool, report func(key string, format string, args ...interface{})) {
+	defer trace()()
+	names := make([]string, 0, len(c.Collectors.EnabledCollectors))
+	for name := range c.Collectors.EnabledCollectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec := c.Collectors.EnabledCollectors[name]
+		table := "Collectors.EnabledCollectors." + name
+		if collectorAvailable != nil && !collectorAvailable(name) {
+			report(table, "collector '%s' not available", name)
+		}
+		for _, p := range []struct{ key, pattern string }{{"Include", spec.Include}, {"Exclude", spec.Exclude}} {
+			if _, err := regexp.Compile(p.pattern); err != nil {
+				report(table+"."+p.key, "invalid %s pattern for collector '%s': %s", p.key, name, err)
+			}
+		}
+		if _, err := regexp.Compile(spec.When.Hostname); err != nil {
+			report(table+".When.Hostname", "invalid Hostname pattern for collector '%s': %s", name, err)
+		}
+		if spec.When.Service != "" && !ValidServiceName(spec.When.Service) {
+			report(table+".When.Service", "invalid service name '%s' for collector '%s'", spec.When.Service, name)
+		}
+		for tag, pattern := range spec.When.Tags {
+			if _, err := regexp.Compile(pattern); err != nil {
+				report(table+".When.Tags."+tag, "invalid pattern for tag '%s' of collector '%s': %s", tag, name, err)
+			}
+		}
+		seen := make(map[string]int)
+		for i, m := range spec.ExportedMetrics {
+			entry := fmt.Sprintf("%s.ExportedMetrics#%d", table, i)
+			if m.ExportName == "" {
+				report(entry, "exported metric #%d of collector '%s' has no ExportName", i+1, name)
+			} else if first, ok := seen[m.ExportName]; ok {
+				report(entry+".ExportName", "duplicate ExportName '%s' in collector '%s', first used by exported metric #%d", m.ExportName, name, first+1)
+			} else {
+				seen[m.ExportName] = i
+			}
+			if len(m.SourceName) == 0 && m.NeedsSource() {
+				report(entry, "exported metric '%s' of collector '%s' has no SourceName", m.ExportName, name)
+			}
+			if numeric := m.MetricType != "Info" && m.MetricType != "StateSet"; numeric && !m.ComputedMetric && len(m.SourceName) > 0 &&
+				numericSource != nil && !numericSource(name, m.SourceName[0]) {
+				report(entry+".SourceName", "exported metric '%s' of collector '%s' reads string property '%s', only Info and StateSet metrics can",
+					m.ExportName, name, m.SourceName[0])
+			}
+			if !metricTypes[m.MetricType] {
+				report(entry+".MetricType", "unknown MetricType '%s' for exported metric '%s'", m.MetricType, m.ExportName)
+			}
+			if m.MetricType == "StateSet" && len(m.States) == 0 {
+				report(entry, "StateSet metric '%s' has no States", m.ExportName)
+			}
+			if m.ComputedMetric {
+				if m.ComputeLogic == "" {
+					report(entry, "computed metric '%s' has no ComputeLogic", m.ExportName)
+				} else if validateExpression != nil {
+					if err := validateExpression(m.ComputeLogic); err != nil {
+						report(entry+".ComputeLogic", "invalid ComputeLogic for metric '%s': %s", m.ExportName, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// ValidateCollectors runs the collector checks of CheckConfig on a configuration that does not come from a file, e.g.
+// a remote document merged into the running configuration, and fails with every problem found
+func ValidateCollectors(c ConfigurationParameters, validateExpression func(expr string) error, numericSource func(collector string, source string) bool) error {
+	defer trace()()
+	var problems []string
+	checkCollectors(c, nil, validateExpression, numericSource, func(key string, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	})
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid collectors:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// checkService reports problems with the listen and service discovery addresses of the merged configuration
+func checkService(configfile string, c ConfigurationParameters) []Problem {
+	defer trace()()
+	data, _ := ioutil.ReadFile(configfile)
+	pos := newLocator(mainFormat(configfile), data)
+	var problems []Problem
+	report := func(key string, format string, args ...interface{}) {
+		problems = append(problems, Problem{File: configfile, Line: pos.line(key), Message: fmt.Sprintf(format, args...), key: key})
+	}
+
+	if c.Service.ListenIP != "" && net.ParseIP(c.Service.ListenIP) == nil {
+		if _, err := net.LookupHost(c.Service.ListenIP); err != nil {
+			report("Service.ListenIP", "malformed listen address '%s'", c.Service.ListenIP)
+		}
+	}
+	if c.Service.ListenPort < 0 || c.Service.ListenPort > 65535 {
+		report("Service.ListenPort", "listen port %d out of range", c.Service.ListenPort)
+	}
+	if !metadataProviders[c.MetadataReporting.Provider] {
+		report("MetadataReporting.Provider", "unknown metadata provider '%s', expected aws, azure, gce, none or auto", c.MetadataReporting.Provider)
+	}
+	if !imdsVersions[c.MetadataReporting.IMDSVersion] {
+		report("MetadataReporting.IMDSVersion", "unknown IMDS version '%s', expected v1, v2 or auto", c.MetadataReporting.IMDSVersion)
+	}
+	if !tagSources[c.AwsTagsToLabels.TagSource] {
+		report("AwsTagsToLabels.TagSource", "unknown tag source '%s', expected imds, api or auto", c.AwsTagsToLabels.TagSource)
+	}
+	if c.MetadataReporting.MetadataURL != "" {
+		if u, err := url.Parse(c.MetadataReporting.MetadataURL); err != nil || u.Scheme == "" || u.Host == "" {
+			report("MetadataReporting.MetadataURL", "malformed metadata URL '%s'", c.MetadataReporting.MetadataURL)
+		}
+	}
+	if c.MetadataReporting.HopLimit < 0 || c.MetadataReporting.HopLimit > 255 {
+		report("MetadataReporting.HopLimit", "hop limit %d out of range", c.MetadataReporting.HopLimit)
+	}
+	if c.MetadataReporting.TokenTTL < 0 || c.MetadataReporting.TokenTTL > 21600 {
+		report("MetadataReporting.TokenTTL", "token TTL %d out of range, at most 21600 seconds", c.MetadataReporting.TokenTTL)
+	}
+	if c.MetadataReporting.ConnectTimeout < 0 || c.MetadataReporting.Timeout < 0 {
+		report("MetadataReporting", "metadata timeouts must not be negative")
+	}
+	if c.RemoteConfig.Enabled && c.RemoteConfig.Key == "" {
+		report("RemoteConfig", "remote configuration is enabled without a Key")
+	}
+	if c.RemoteConfig.WaitTime < 0 {
+		report("RemoteConfig.WaitTime", "wait time %d must not be negative", c.RemoteConfig.WaitTime)
+	}
+	if c.ServiceDiscovery.Enabled && !discoveryBackends[c.ServiceDiscovery.Backend] {
+		report("ServiceDiscovery.Backend", "unknown service discovery backend '%s', expected consul or file_sd", c.ServiceDiscovery.Backend)
+	}
+	if c.ServiceDiscovery.Enabled && c.ServiceDiscovery.AddressCIDR != "" {
+		if _, _, err := net.ParseCIDR(c.ServiceDiscovery.AddressCIDR); err != nil {
+			report("ServiceDiscovery.AddressCIDR", "invalid AddressCIDR: %s", err)
+		}
+	}
+	if c.ServiceDiscovery.Enabled && c.ServiceDiscovery.Backend == "file_sd" {
+		if c.ServiceDiscovery.FileSDPath == "" {
+			report("ServiceDiscovery", "the file_sd backend requires a FileSDPath")
+		} else if fi, err := os.Stat(filepath.Dir(c.ServiceDiscovery.FileSDPath)); err != nil || !fi.IsDir() {
+			report("ServiceDiscovery.FileSDPath", "directory of FileSDPath '%s' does not exist", c.ServiceDiscovery.FileSDPath)
+		}
+	}
+	if c.ServiceDiscovery.Enabled && (c.ServiceDiscovery.Backend == "" || c.ServiceDiscovery.Backend == "consul") {
+		if c.ServiceDiscovery.RemoteEndpoint == "" {
+			report("ServiceDiscovery", "service discovery is enabled without a RemoteEndpoint")
+		}
+		if c.ServiceDiscovery.RemotePort <= 0 || c.ServiceDiscovery.RemotePort > 65535 {
+			report("ServiceDiscovery.RemotePort", "remote port %d out of range", c.ServiceDiscovery.RemotePort)
+		}
+		if !consulSchemes[c.ServiceDiscovery.Scheme] {
+			report("ServiceDiscovery.Scheme", "unknown scheme '%s', expected http or https", c.ServiceDiscovery.Scheme)
+		}
+		if (c.ServiceDiscovery.CertFile == "") != (c.ServiceDiscovery.KeyFile == "") {
+			report("ServiceDiscovery", "CertFile and KeyFile must be set together")
+		}
+		files := []struct{ key, file string }{
+			{"TokenFile", c.ServiceDiscovery.TokenFile},
+			{"CAFile", c.ServiceDiscovery.CAFile},
+			{"CertFile", c.ServiceDiscovery.CertFile},
+			{"KeyFile", c.ServiceDiscovery.KeyFile},
+		}
+		for _, f := range files {
+			if f.file == "" {
+				continue
+			}
+			if _, err := ioutil.ReadFile(f.file); err != nil {
+				report("ServiceDiscovery."+f.key, "cannot read %s: %s", f.key, err)
+			}
+		}
+		if !consulModes[c.ServiceDiscovery.Mode] {
+			report("ServiceDiscovery.Mode", "unknown registration mode '%s', expected catalog or agent", c.ServiceDiscovery.Mode)
+		}
+		if !consulCheckTypes[c.ServiceDiscovery.CheckType] {
+			report("ServiceDiscovery.CheckType", "unknown check type '%s', expected http or ttl", c.ServiceDiscovery.CheckType)
+		}
+		if !consulSchemes[c.ServiceDiscovery.CheckScheme] {
+			report("ServiceDiscovery.CheckScheme", "unknown check scheme '%s', expected http or https", c.ServiceDiscovery.CheckScheme)
+		}
+		if c.ServiceDiscovery.CheckInterval < 0 || c.ServiceDiscovery.CheckTimeout < 0 || c.ServiceDiscovery.CheckTTL < 0 ||
+			c.ServiceDiscovery.DeregisterCriticalServiceAfter < 0 || c.ServiceDiscovery.RefreshInterval < 0 {
+			report("ServiceDiscovery", "check and refresh durations must not be negative")
+		}
+	}
+	return problems
+}
+
+// keyPositions maps key paths of a TOML document to the line they are defined on. Entries of arrays of tables are
+// recorded twice, with their index (Table#2.Key) and without it for the first entry (Table.Key).
+type keyPositions map[string]int
+
+func locateKeys(doc string) keyPositions {
+	defer trace()()
+	pos := make(keyPositions)
+	counts := make(map[string]int)
+	table := ""
+	set := func(key string, line int) {
+		if _, ok := pos[key]; !ok {
+			pos[key] = line
+		}
+	}
+	for i, l := range strings.Split(doc, "\n") {
+		l = strings.TrimSpace(l)
+		switch {
+		case strings.HasPrefix(l, "[["):
+			name := strings.TrimSpace(strings.Trim(strings.SplitN(l, "]]", 2)[0], "["))
+			table = fmt.Sprintf("%s#%d", name, counts[name])
+			counts[name]++
+			set(name, i+1)
+			set(table, i+1)
+		case strings.HasPrefix(l, "["):
+			table = strings.TrimSpace(strings.Trim(strings.SplitN(l, "]", 2)[0], "["))
+			set(table, i+1)
+		case strings.Contains(l, "=") && !strings.HasPrefix(l, "#"):
+			key := strings.Trim(strings.TrimSpace(strings.SplitN(l, "=", 2)[0]), `"`)
+			set(table+"."+key, i+1)
+			if j := strings.LastIndex(table, "#"); j >= 0 {
+				set(table[:j]+"."+key, i+1)
+			}
+		}
+	}
+	return pos
+}
+
+// line returns the line of a key, or of its closest enclosing table if the key itself is not found
+func (p keyPositions) line(key string) int {
+	for key != "" {
+		if l, ok := p[key]; ok {
+			return l
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			break
+		}
+		key = key[:i]
+	}
+	return 0
+}
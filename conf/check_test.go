@@ -0,0 +1,250 @@
+package conf
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "wmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`[AwsTagsToLabels]
+    TagsToCaptue = []
+[Collectors.EnabledCollectors.tcpu]
+    [[Collectors.EnabledCollectors.tcpu.ExportedMetrics]]
+        SourceName = ["time_total"]
+        ExportName = "idle"
+    [[Collectors.EnabledCollectors.tcpu.ExportedMetrics]]
+        ExportName = "idle"
+        ComputedMetric = true
+        ComputeLogic = "bad"
+[Collectors.EnabledCollectors.nope]
+    [Collectors.EnabledCollectors.nope.When]
+        Service = "MSSQL/BAD"
+`)
+	f.Close()
+
+	available := func(name string) bool { return name == "tcpu" }
+	validate := func(expr string) error {
+		if expr == "bad" {
+			return errors.New("cannot parse")
+		}
+		return nil
+	}
+	expected := []string{
+		":2: unknown key AwsTagsToLabels.TagsToCaptue",
+		":11: collector 'nope' not available",
+		":13: invalid service name 'MSSQL/BAD' for collector 'nope'",
+		":8: duplicate ExportName 'idle'",
+		":10: invalid ComputeLogic for metric 'idle': cannot parse",
+	}
+	problems := CheckConfig(f.Name(), "", available, validate, nil)
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), problems)
+	}
+	for i, p := range problems {
+		if !strings.Contains(p.String(), expected[i]) {
+			t.Errorf("expected problem %q, got %q", expected[i], p)
+		}
+	}
+}
+
+func TestCheckConfigFragments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"wmi_exporter.toml": `Include = "conf.d/*.toml"
+[Service]
+    ListenPort = 9103
+[Collectors.EnabledCollectors.tcpu]
+    [[Collectors.EnabledCollectors.tcpu.ExportedMetrics]]
+        SourceName = ["time_total"]
+        ExportName = "idle"
+`,
+		"conf.d/10-team.toml": `[Collectors.EnabledCollectors.tcpu]
+    [[Collectors.EnabledCollectors.tcpu.ExportedMetrics]]
+        SourceName = ["PercentIdleTime"]
+        ExportName = "idle"
+    [[Collectors.EnabledCollectors.tcpu.ExportedMetrics]]
+        SourceName = ["PercentUserTime"]
+        ExportName = "user"
+`,
+		"conf.d/20-other.toml": `[Service]
+    ListenPort = 9200
+`,
+	}
+	os.Mkdir(filepath.Join(dir, "conf.d"), 0755)
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{
+		"10-team.toml:2: Collectors.EnabledCollectors.tcpu.ExportedMetrics: duplicate entry 'idle'",
+		"20-other.toml:2: Service.ListenPort: set to 9200, but already set to 9103 in " + filepath.Join(dir, "wmi_exporter.toml"),
+	}
+	problems := CheckConfig(filepath.Join(dir, "wmi_exporter.toml"), "", nil, nil, nil)
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), problems)
+	}
+	for i, p := range problems {
+		if !strings.Contains(p.String(), expected[i]) {
+			t.Errorf("expected problem %q, got %q", expected[i], p)
+		}
+	}
+}
+
+func TestDecodeFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"wmi_exporter.toml": `[Service]
+    ListenPort = 9103
+[Collectors.EnabledCollectors.tcpu]
+    SampleInterval = 5
+    [[Collectors.EnabledCollectors.tcpu.ExportedMetrics]]
+        SourceName = ["PercentIdleTime"]
+        ExportName = "idle"
+        Buckets = [0.5, 1.0]
+`,
+		"wmi_exporter.yaml": `service:
+  listenport: 9103
+Collectors:
+  EnabledCollectors:
+    tcpu:
+      SampleInterval: 5
+      ExportedMetrics:
+        - SourceName: [PercentIdleTime]
+          ExportName: idle
+          Buckets: [0.5, 1]
+`,
+		"wmi_exporter.json": `{
+  "Service": {"ListenPort": 9103},
+  "Collectors": {"EnabledCollectors": {"tcpu": {
+    "SampleInterval": 5,
+    "ExportedMetrics": [{"SourceName": ["PercentIdleTime"], "ExportName": "idle", "Buckets": [0.5, 1]}]
+  }}}
+}
+`,
+	}
+	decoded := make(map[string]ConfigurationParameters)
+	for name, content := range files {
+		f := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(f, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var c ConfigurationParameters
+		meta, _, err := decodeFile(f, formatOf(f), &c)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !meta.isDefined("Collectors", "EnabledCollectors", "tcpu", "ExportedMetrics") || len(meta.undecoded) > 0 {
+			t.Errorf("%s: unexpected keys %v, undecoded %v", name, meta.defined, meta.undecoded)
+		}
+		decoded[name] = c
+	}
+	for _, name := range []string{"wmi_exporter.yaml", "wmi_exporter.json"} {
+		if !reflect.DeepEqual(decoded[name], decoded["wmi_exporter.toml"]) {
+			t.Errorf("%s decoded to %+v, expected %+v", name, decoded[name], decoded["wmi_exporter.toml"])
+		}
+	}
+}
+
+func TestCheckConfigYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := filepath.Join(dir, "wmi_exporter.yml")
+	ioutil.WriteFile(f, []byte(`AwsTagsToLabels:
+  TagsToCaptue: []
+Collectors:
+  EnabledCollectors:
+    tcpu:
+      ExportedMetrics:
+        - SourceName: [time_total]
+          ExportName: idle
+        - ExportName: idle
+          MetricType: Gauge
+          ComputedMetric: true
+          ComputeLogic: bad
+`), 0644)
+
+	validate := func(expr string) error {
+		if expr == "bad" {
+			return errors.New("cannot parse")
+		}
+		return nil
+	}
+	expected := []string{
+		":2: unknown key AwsTagsToLabels.TagsToCaptue",
+		":9: duplicate ExportName 'idle'",
+		":12: invalid ComputeLogic for metric 'idle': cannot parse",
+	}
+	problems := CheckConfig(f, "", nil, validate, nil)
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), problems)
+	}
+	for i, p := range problems {
+		if !strings.Contains(p.String(), expected[i]) {
+			t.Errorf("expected problem %q, got %q", expected[i], p)
+		}
+	}
+
+	ioutil.WriteFile(f, []byte("Service:\n  ListenPort: [\n"), 0644)
+	if problems := CheckConfig(f, "", nil, nil, nil); len(problems) != 1 || problems[0].Line == 0 {
+		t.Errorf("expected one located syntax error, got %v", problems)
+	}
+}
+
+func TestExplicitFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"wmi_exporter.conf": `Include: "conf.d/*"
+Service:
+  ListenPort: 9103
+`,
+		"conf.d/10-team.toml": `[Collectors.EnabledCollectors.tcpu]
+    [[Collectors.EnabledCollectors.tcpu.ExportedMetrics]]
+        SourceName = ["PercentIdleTime"]
+        ExportName = "idle"
+`,
+		"conf.d/README.txt": "not a fragment\n",
+	}
+	os.Mkdir(filepath.Join(dir, "conf.d"), 0755)
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	Format = "yaml"
+	defer func() { Format = "" }()
+	if problems := CheckConfig(filepath.Join(dir, "wmi_exporter.conf"), "", nil, nil, nil); len(problems) > 0 {
+		t.Errorf("expected the format to apply to the main file only, got %v", problems)
+	}
+	fragments, err := fragmentFiles(filepath.Join(dir, "wmi_exporter.conf"), "conf.d/*")
+	if err != nil || len(fragments) != 1 || filepath.Base(fragments[0]) != "10-team.toml" {
+		t.Errorf("expected fragments to be filtered by extension, got %v, %v", fragments, err)
+	}
+}
@@ -1,15 +1,12 @@
 package conf
 
 import (
+	"fmt"
+	"regexp"
 	"strconv"
-
-	"github.com/prometheus/common/log"
-
-	"github.com/djonnala/go-tracey"
-
 	"strings"
 
-	"github.com/BurntSushi/toml"
+	"github.com/djonnala/go-tracey"
 )
 
 //TraceConfig provides the global config for tracing method calls
@@ -24,8 +21,8 @@ var UCMConfig ConfigurationParameters
 const (
 	// defaultCollectors provides a default list of common collectors
 	defaultCollectors = "cpu,cs,logical_disk,net,os,service,system"
-	// defaultListenAddress points to all NICs on port 9182
-	defaultListenAddress = ":9182"
+	// defaultListenPort binds to port 9182 on all NICs
+	defaultListenPort = 9182
 	// defaultMetricsPath defines the constant /metrics
 	defaultMetricsPath = "/metrics"
 	// DefaultPlaceholder is the string template that consumers can use to include the entire list of default collectors when providing their list of enabled collectors
@@ -34,7 +31,10 @@ const (
 
 //ConfigurationParameters provides the struct to hold configuration parameters from config file
 type ConfigurationParameters struct {
+	//title names the configuration
 	Title string
+	//include is a glob of configuration fragments, relative to this file, merged into it in lexical order
+	Include string
 	//serviceDiscovery captures configuration parameters needed for service discovery registration with Consul
 	ServiceDiscovery ConsulConf
 	//metadataReporting captures which metadata to be registered with service into consul for use during discovery
@@ -45,128 +45,348 @@ type ConfigurationParameters struct {
 	Collectors CollectorConf
 	//service captures agent related configurations
 	Service ServiceConf
+	//metricRelabelConfigs rewrite or drop the series of every collector before exposition, in order
+	MetricRelabelConfigs []RelabelConfig
+	//externalLabels are constant labels attached to every metric of every collector
+	ExternalLabels map[string]string
+	//externalLabelsFile names a file of name=value lines adding to ExternalLabels, e.g. written by provisioning
+	ExternalLabelsFile string
+	//remoteConfig reads collector settings from Consul KV, merged over this file and followed for changes
+	RemoteConfig RemoteConfigConf
+}
+
+//RemoteConfigConf captures a configuration document in Consul KV. Its Collectors table is merged over the one of the
+//configuration file, overriding it, and the collectors are rebuilt whenever the document changes.
+type RemoteConfigConf struct {
+	//enabled reads the document at startup and watches it, using the connection settings of ServiceDiscovery
+	Enabled bool
+	//key is the KV key of the TOML document. {datacenter}, {hostname} and {tag:NAME} are replaced with the
+	//ServiceDiscovery datacenter, the host name and the value of a tag of the instance, e.g. wmi_exporter/{datacenter}/{tag:role}.
+	Key string
+	//cacheFile keeps the last document read, used when Consul cannot be reached at startup
+	CacheFile string
+	//waitTime, in seconds, bounds each blocking query watching the key, 300 by default
+	WaitTime int
 }
 
 //ConsulConf captures configuration parameters needed for service discovery registration with Consul
 type ConsulConf struct {
-	Enabled             bool
-	RemoteEndpoint      string
-	RemotePort          int
-	Datacenter          string
-	ServiceID           string
+	//enabled registers the exporter with Consul at startup and deregisters it on shutdown
+	Enabled bool
+	//backend is consul (the default) or file_sd, writing a Prometheus file_sd target file to FileSDPath instead
+	Backend string
+	//fileSDPath is the target file written by the file_sd backend, e.g. on a share Prometheus reads from. It is removed
+	//on shutdown.
+	FileSDPath string
+	//remoteEndpoint is the host name or IP of the Consul server
+	RemoteEndpoint string
+	//remotePort is the HTTP port of the Consul server
+	RemotePort int
+	//scheme is http (the default) or https, verifying the server certificate against CAFile
+	Scheme string
+	//token is the ACL token sent with every Consul request, best given as an env: or file: reference
+	Token string
+	//tokenFile names a file holding the ACL token, read on every registration and taking precedence over Token
+	TokenFile string
+	//caFile is a PEM file of the certificate authorities trusted for the Consul server, the system roots when empty
+	CAFile string
+	//certFile is the PEM client certificate presented to Consul, for servers verifying clients
+	CertFile string
+	//keyFile is the PEM private key of CertFile
+	KeyFile string
+	//tlsServerName is the name the Consul server certificate is verified against, RemoteEndpoint when empty
+	TLSServerName string
+	//insecureSkipVerify disables the verification of the Consul server certificate, for testing only
+	InsecureSkipVerify bool
+	//namespace is the Consul Enterprise namespace the service is registered in
+	Namespace string
+	//partition is the Consul Enterprise admin partition the service is registered in
+	Partition string
+	//datacenter is the Consul datacenter to register in
+	Datacenter string
+	//serviceID identifies the registered service instance
+	ServiceID string
+	//registerServiceName is the service name registered with Consul
 	RegisterServiceName string
+	//mode is catalog (the default), writing a catalog entry that stays passing, or agent, registering through the
+	//Consul agent at RemoteEndpoint with a health check. Agents run the checks of the services registered with them,
+	//so RemoteEndpoint has to be the address of the agent on this host.
+	Mode string
+	//checkType is http (the default), with the agent polling /health, or ttl, with the exporter reporting in itself
+	CheckType string
+	//checkScheme is the scheme of the /health URL the agent polls, http (the default) or https, e.g. behind a proxy
+	//terminating TLS in front of the exporter
+	CheckScheme string
+	//checkInterval, in seconds, is how often the agent polls /health, 10 by default
+	CheckInterval int
+	//checkTimeout, in seconds, bounds a /health request of the agent, 5 by default
+	CheckTimeout int
+	//checkTTL, in seconds, is how long a ttl check stays passing without the exporter reporting in, 30 by default
+	CheckTTL int
+	//deregisterCriticalServiceAfter, in seconds, has the agent remove the service once its check failed that long, so
+	//that crashed exporters do not linger; Consul enforces at least a minute. 0 keeps failed services registered.
+	DeregisterCriticalServiceAfter int
+	//addressInterface restricts the registered address to the interface of that name, e.g. Ethernet0
+	AddressInterface string
+	//addressCIDR restricts the registered address to a network, e.g. 10.0.0.0/8 or 2001:db8::/32
+	AddressCIDR string
+	//addressFromRoute registers the local address of the route to RemoteEndpoint, the one Consul sees the host from. It
+	//fails when that is a loopback or link-local address, e.g. with a local Consul agent
+	AddressFromRoute bool
+	//refreshInterval, in seconds, is how often the exporter checks that it is still registered with unchanged tags
+	//and registers again otherwise, 60 by default
+	RefreshInterval int
 }
 
+// metadataProviders, imdsVersions and tagSources list the accepted values of MetaDataConf.Provider,
+// MetaDataConf.IMDSVersion and LabelConf.TagSource
+var (
+	metadataProviders = map[string]bool{"": true, "auto": true, "aws": true, "azure": true, "gce": true, "none": true}
+	imdsVersions      = map[string]bool{"": true, "v1": true, "v2": true, "auto": true}
+	tagSources        = map[string]bool{"": true, "auto": true, "imds": true, "api": true}
+)
+
+// discoveryBackends, consulSchemes, consulModes and consulCheckTypes list the accepted values of ConsulConf.Backend,
+// ConsulConf.Scheme and ConsulConf.CheckScheme, ConsulConf.Mode and ConsulConf.CheckType
+var (
+	discoveryBackends = map[string]bool{"": true, "consul": true, "file_sd": true}
+	consulSchemes     = map[string]bool{"": true, "http": true, "https": true}
+	consulModes       = map[string]bool{"": true, "catalog": true, "agent": true}
+	consulCheckTypes  = map[string]bool{"": true, "http": true, "ttl": true}
+)
+
 //MetaDataConf captures which metadata to be registered with service into consul for use during discovery
 type MetaDataConf struct {
-	Enabled    bool
-	AWSRegion  string
+	//enabled reports AWS instance tags as service metadata when registering with Consul
+	Enabled bool
+	//provider is the cloud whose metadata service describes the host: aws, azure, gce, none, or auto (the default),
+	//trying each in that order
+	Provider string
+	//awsRegion is the AWS region used to look up instance tags, the region of the instance when empty
+	AWSRegion string
+	//metadataURL is the base URL of the instance metadata service, by default http://169.254.169.254 on AWS and
+	//Azure and http://metadata.google.internal on GCE, e.g. a local stand-in for testing
+	MetadataURL string
+	//imdsVersion is v2 (the default), requesting a session token first as hardened instances require, v1 without
+	//token, or auto, falling back to v1 when no token can be had
+	IMDSVersion string
+	//tokenTTL, in seconds, is the lifetime of IMDSv2 session tokens, 21600 by default
+	TokenTTL int
+	//hopLimit is the IP TTL of the requests sent to the metadata service, the system default when 0. The hop limit of
+	//the token responses is an instance setting, HttpPutResponseHopLimit.
+	HopLimit int
+	//connectTimeout, in seconds, bounds connecting to the metadata service, 1 by default
+	ConnectTimeout int
+	//timeout, in seconds, bounds each metadata request as a whole, 5 by default
+	Timeout int
+	//attributes maps instance tags to the service metadata reported, with keys sanitized to what Consul accepts
 	Attributes []TagLabelMap
+	//legacyTags reports Attributes as key=value; service tags instead of service metadata
+	LegacyTags bool
 }
 
 //LabelConf captures the aws tags that should be added to reported metrics as Labels
 type LabelConf struct {
-	Enabled       bool
+	//enabled adds the instance tags, AWS and Azure tags or GCE labels, as labels to the metrics of templated collectors
+	Enabled bool
+	//refreshPeriod, in seconds, is how often the instance tags are fetched again
 	RefreshPeriod int
-	TagsToCaptue  []TagLabelMap
+	//tagSource is where AWS tags come from: imds, the tags/instance endpoint of the instance metadata service, which
+	//needs instance metadata tags enabled on the instance; api, ec2:DescribeTags, which needs IAM permissions and
+	//access to the EC2 API; or auto (the default), the metadata service then the API
+	TagSource string
+	//tagsToCapture is not read: the labels added are mapped from the instance tags by MetadataReporting.Attributes
+	TagsToCapture []TagLabelMap
 }
 
 //TagLabelMap captures a mapping between one or more WMI metrics and the name it should be reported with
 type TagLabelMap struct {
-	TagName        []string
-	LabelName      string
+	//tagName lists the instance tags whose values make up the label
+	TagName []string
+	//labelName is the name of the label reported
+	LabelName string
+	//mergeSeparator joins the values of several tags
 	MergeSeparator string
-	MissingLabel   string
+	//missingLabel is the value used for a tag the instance does not have
+	MissingLabel string
 }
 
 //CollectorConf captures the list of collectors to use
 type CollectorConf struct {
-	GoCollectionEnabled       bool
+	//goCollectionEnabled is reserved for exposing Go runtime metrics
+	GoCollectionEnabled bool
+	//exporterCollectionEnabled is reserved for exposing exporter metrics
 	ExporterCollectionEnabled bool
-	WmiCollectionEnabled      bool
-	AgentCollectionEnabled    bool
-	MetricTimeout             int
-	EnabledCollectors         map[string]CollectorSpec
+	//wmiCollectionEnabled is reserved for enabling WMI collection as a whole
+	WmiCollectionEnabled bool
+	//agentCollectionEnabled is reserved for exposing agent metrics
+	AgentCollectionEnabled bool
+	//metricTimeout is reserved for limiting the time a collection may take
+	MetricTimeout int
+	//enabledCollectors holds the options of each collector to run, keyed by collector name
+	EnabledCollectors map[string]CollectorSpec
 }
 
-// CollectorSpec ...
+// CollectorSpec captures the options of a single collector
 type CollectorSpec struct {
-	Namespace       string
-	DefaultDrop     bool
+	//Namespace prefixes the names of the exported metrics of templated collectors
+	Namespace string
+	//DefaultDrop drops the built-in metrics of a templated collector, keeping only its ExportedMetrics
+	DefaultDrop bool
+	//SampleInterval, in seconds, samples numeric exported metrics between scrapes and exposes their _min, _max, _avg and _last
+	SampleInterval int
+	//Include is a regular expression on the instance label of the collector (site, volume, nic, core or service
+	//name). Only matching instances are reported.
+	Include string
+	//Exclude is a regular expression on the instance label of the collector. Matching instances are not reported.
+	Exclude string
+	//ExportedMetrics are the metrics a templated collector exports from its source values
 	ExportedMetrics []MetricMap
+	//When restricts the collector to hosts matching a condition, so that one configuration serves different fleets
+	When CollectorCondition
+}
+
+//CollectorCondition restricts a collector to hosts with matching attributes. Every condition set must hold, and a
+//collector without conditions runs on every host.
+type CollectorCondition struct {
+	//hostname is a regular expression the host name must match, ignoring case
+	Hostname string
+	//tags maps instance tags, or instance attributes such as region, to regular expressions their values must match
+	Tags map[string]string
+	//wmiClass names a WMI class that must exist on the host, e.g. Win32_PerfRawData_W3SVC_WebService
+	WmiClass string
+	//service names a Windows service that must be installed on the host, e.g. DNS
+	Service string
+}
+
+// IsSet tells whether any condition is set
+func (c CollectorCondition) IsSet() bool {
+	return c.Hostname != "" || len(c.Tags) > 0 || c.WmiClass != "" || c.Service != ""
+}
+
+// ValidServiceName tells whether name can name a Windows service, e.g. MSSQL$SQLEXPRESS: at most 256 characters,
+// without / or \
+func ValidServiceName(name string) bool {
+	name = strings.TrimSpace(name)
+	return name != "" && len(name) <= 256 && !strings.ContainsAny(name, `/\`)
 }
 
 //MetricMap captures a mapping between one or more WMI metrics and the name it should be reported with
 type MetricMap struct {
-	SourceName     []string
-	ExportName     string
-	Desc           string
-	MetricType     string
+	//sourceName lists the source values the metric is built from
+	SourceName []string
+	//exportName is the name of the metric, unique within the collector
+	ExportName string
+	//desc is added to the help of the metric
+	Desc string
+	//metricType is Gauge (the default), Counter, Histogram, Summary, Info or StateSet
+	MetricType string
+	//computedMetric computes the value with ComputeLogic instead of taking the first SourceName
 	ComputedMetric bool
-	ComputeLogic   string
+	//computeLogic is the expression computing the value of a computed metric
+	ComputeLogic string
+	//buckets are the upper bounds of the buckets of a Histogram
+	Buckets []float64
+	//objectives are the quantiles of a Summary
+	Objectives []Objective
+	//states are the values a StateSet metric reports one series for
+	States []string
 }
 
-//ServiceConf captures agent related configurations
-type ServiceConf struct {
-	ListenIP           string
-	ListenPort         int
-	MetricPath         string
-	CollectionInterval int
-	ServiceName        string
+// NeedsSource tells whether a metric has to name a SourceName. Computed metrics read their sources from ComputeLogic
+// and Info metrics may carry no labels, but StateSet metrics always read their state from the first SourceName.
+func (m MetricMap) NeedsSource() bool {
+	defer trace()()
+	return m.MetricType == "StateSet" || !m.ComputedMetric && m.MetricType != "Info"
 }
 
-// GetAddress returns a fully formatted host-port combination as needed for Consul Endpoint configuration, based on ip/fqdn and port entries made in config file
-func (c ConsulConf) GetAddress() string {
-	defer trace()()
-	return "http://" + c.RemoteEndpoint + ":" + strconv.Itoa(c.RemotePort)
+//Objective captures a quantile and its allowed error for metrics exported with MetricType Summary
+type Objective struct {
+	//quantile is the quantile to report, between 0 and 1
+	Quantile float64
+	//error is the allowed absolute error of the quantile
+	Error float64
 }
 
-// GetAddress returns a fully formatted host-port combination as needed for binding the service startup, based on ip/fqdn and port entries made in config file
-func (s ServiceConf) GetAddress() string {
-	defer trace()()
-	return s.ListenIP + ":" + strconv.Itoa(s.ListenPort)
+//RelabelConfig captures a relabeling rule with the semantics of a Prometheus metric_relabel_configs entry. Separator
+//defaults to ";", Regex to "(.*)", Replacement to "$1" and Action to "replace".
+type RelabelConfig struct {
+	//sourceLabels are the labels whose values, joined by Separator, are matched against Regex
+	SourceLabels []string
+	//separator joins the values of SourceLabels
+	Separator string
+	//targetLabel is the label written by the replace and hashmod actions
+	TargetLabel string
+	//regex is matched against the joined source values, or the label names for labelmap, labeldrop and labelkeep
+	Regex string
+	//modulus is the modulus of the hash for the hashmod action
+	Modulus uint64
+	//replacement is written to TargetLabel, with $1 and ${name} referring to groups of Regex
+	Replacement string
+	//action is one of replace, keep, drop, hashmod, labelmap, labeldrop and labelkeep
+	Action string
 }
 
-// setAddress allows for setting the IP & Port for service binding based on command line params
-func (s ServiceConf) setAddress(addr string) {
-	defer trace()()
-	a := strings.Split(addr, ":")
-	s.ListenIP = a[0]
-	p, err := strconv.Atoi(a[1])
-	if err != nil {
-		log.Fatalf("Cannot parse the address %s into IP=%s and Port=%s; Error=%s", addr, a[0], a[1], err)
-	}
-	s.ListenPort = p
+// relabelActions lists the accepted values of RelabelConfig.Action, where empty means replace
+var relabelActions = map[string]bool{
+	"":          true,
+	"replace":   true,
+	"keep":      true,
+	"drop":      true,
+	"hashmod":   true,
+	"labelmap":  true,
+	"labeldrop": true,
+	"labelkeep": true,
 }
 
-// InitializeFromConfig reads configuration parameters from configuration file and initializes this service
-func InitializeFromConfig(configfile string, listenAddress string, metricsPath string, enabledCollectors string) ConfigurationParameters {
+// Validate reports the first problem with a relabeling rule
+func (r RelabelConfig) Validate() error {
 	defer trace()()
-	//	UCMConfig := ConfigurationParameters{}
-
-	if configfile != "" {
-		_, err := toml.DecodeFile(configfile, &UCMConfig)
-		if err != nil {
-			log.Fatalf("Cannot parse configuration file at %s. Error=%s", configfile, err)
-		}
+	if !relabelActions[strings.ToLower(r.Action)] {
+		return fmt.Errorf("unknown relabel action '%s'", r.Action)
 	}
-
-	//allow override of configuration file values with command line params, as long as they are different from defaults or if the conf file does not have a value for those
-	if listenAddress != DefaultPlaceholder || len(UCMConfig.Service.GetAddress()) == 0 {
-		UCMConfig.Service.setAddress(strings.Replace(listenAddress, DefaultPlaceholder, defaultListenAddress, 1))
+	if _, err := regexp.Compile(r.Regex); err != nil {
+		return fmt.Errorf("invalid relabel regex '%s': %s", r.Regex, err)
 	}
-	if metricsPath != DefaultPlaceholder || len(UCMConfig.Service.MetricPath) == 0 {
-		UCMConfig.Service.MetricPath = strings.Replace(metricsPath, DefaultPlaceholder, defaultMetricsPath, 1)
-	}
-	if enabledCollectors != DefaultPlaceholder || len(UCMConfig.Collectors.EnabledCollectors) == 0 {
-		for _, v := range strings.Split(enabledCollectors, ",") {
-			if _, ok := UCMConfig.Collectors.EnabledCollectors[v]; !ok {
-				UCMConfig.Collectors.EnabledCollectors[v] = CollectorSpec{Namespace: "wmi", DefaultDrop: false}
-			}
+	switch strings.ToLower(r.Action) {
+	case "", "replace":
+		if r.TargetLabel == "" {
+			return fmt.Errorf("relabel action replace requires a TargetLabel")
+		}
+	case "hashmod":
+		if r.TargetLabel == "" || r.Modulus == 0 {
+			return fmt.Errorf("relabel action hashmod requires a TargetLabel and a Modulus")
 		}
-		//		UCMConfig.Collectors.EnabledCollectors = strings.Replace(, DefaultPlaceholder, defaultCollectors, -1)
 	}
+	return nil
+}
 
-	//at this point, conf is a fully loaded configuration now; now initialize everything from conf
-	return UCMConfig
+//ServiceConf captures agent related configurations
+type ServiceConf struct {
+	//listenIP is the address the HTTP server binds to, all addresses when empty
+	ListenIP string
+	//listenPort is the port the HTTP server listens on
+	ListenPort int
+	//metricPath is the URL path metrics are exposed on
+	MetricPath string
+	//collectionInterval is reserved for scheduling collections
+	CollectionInterval int
+	//serviceName is the name of the Windows service the exporter runs as
+	ServiceName string
+}
+
+// GetAddress returns a fully formatted host-port combination as needed for Consul Endpoint configuration, based on ip/fqdn and port entries made in config file
+func (c ConsulConf) GetAddress() string {
+	defer trace()()
+	scheme := "http"
+	if c.Scheme != "" {
+		scheme = c.Scheme
+	}
+	return scheme + "://" + c.RemoteEndpoint + ":" + strconv.Itoa(c.RemotePort)
+}
+
+// GetAddress returns a fully formatted host-port combination as needed for binding the service startup, based on ip/fqdn and port entries made in config file
+func (s ServiceConf) GetAddress() string {
+	defer trace()()
+	return s.ListenIP + ":" + strconv.Itoa(s.ListenPort)
 }
@@ -0,0 +1,275 @@
+package conf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Format forces the format of the main configuration file (toml, yaml or json). When empty, the format is detected
+// from the file extension, defaulting to toml. Fragments are always detected from their extension.
+var Format string
+
+// fileMeta lists the keys a configuration file defined, as lower-cased dotted paths including every enclosing table,
+// and the keys that did not match any configuration value
+type fileMeta struct {
+	defined   map[string]bool
+	undecoded []string
+}
+
+func (m fileMeta) isDefined(path ...string) bool {
+	return m.defined[strings.ToLower(strings.Join(path, "."))]
+}
+
+func (m *fileMeta) define(path []string) {
+	for i := range path {
+		m.defined[strings.ToLower(strings.Join(path[:i+1], "."))] = true
+	}
+}
+
+// A DecodeError is a configuration file that cannot be decoded, with the line of the error if known
+type DecodeError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("Cannot parse configuration file at %s. Error=%s", e.File, e.Err)
+}
+
+// mainFormat returns the format of the main configuration file
+func mainFormat(file string) string {
+	if Format != "" {
+		return strings.ToLower(Format)
+	}
+	return formatOf(file)
+}
+
+// formatOf returns the format of a configuration file from its extension
+func formatOf(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".json":
+		return "json"
+	}
+	return "toml"
+}
+
+// isConfigFile tells whether a file found through Include is a configuration fragment
+func isConfigFile(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".toml", ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+var errorLine = regexp.MustCompile(`line (\d+)`)
+
+// decodeFile decodes a configuration file in the given format into c. Field names match case-insensitively in all
+// formats, as they do in TOML.
+func decodeFile(file string, format string, c *ConfigurationParameters) (fileMeta, []byte, error) {
+	defer trace()()
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fileMeta{defined: make(map[string]bool)}, nil, &DecodeError{File: file, Err: err}
+	}
+	meta, err := decodeData(file, format, data, c)
+	return meta, data, err
+}
+
+// decodeData decodes a configuration document in the given format into c. file names the document in errors.
+func decodeData(file string, format string, data []byte, c *ConfigurationParameters) (fileMeta, error) {
+	defer trace()()
+	meta := fileMeta{defined: make(map[string]bool)}
+	var err error
+	switch format {
+	case "toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			e := &DecodeError{File: file, Err: err}
+			if m := errorLine.FindStringSubmatch(err.Error()); m != nil {
+				e.Line, _ = strconv.Atoi(m[1])
+			}
+			return meta, e
+		}
+		for _, k := range md.Keys() {
+			meta.define(k)
+		}
+		for _, k := range md.Undecoded() {
+			meta.undecoded = append(meta.undecoded, k.String())
+		}
+	case "yaml", "json":
+		var doc interface{}
+		if format == "yaml" {
+			err = yaml.Unmarshal(data, &doc)
+		} else {
+			err = json.Unmarshal(data, &doc)
+		}
+		if err != nil {
+			return meta, &DecodeError{File: file, Line: errorLineOf(data, err), Err: err}
+		}
+		doc = normalize(doc)
+		walkKeys(doc, reflect.TypeOf(*c), nil, &meta)
+		// encoding/json matches field names case-insensitively, like the TOML decoder
+		b, err := json.Marshal(doc)
+		if err == nil {
+			err = json.Unmarshal(b, c)
+		}
+		if err != nil {
+			return meta, &DecodeError{File: file, Err: err}
+		}
+	default:
+		return meta, &DecodeError{File: file, Err: fmt.Errorf("unknown configuration format %s", format)}
+	}
+	return meta, nil
+}
+
+// errorLineOf finds the line of a YAML or JSON decoding error
+func errorLineOf(data []byte, err error) int {
+	if e, ok := err.(*json.SyntaxError); ok {
+		return bytes.Count(data[:e.Offset], []byte("\n")) + 1
+	}
+	if m := errorLine.FindStringSubmatch(err.Error()); m != nil {
+		l, _ := strconv.Atoi(m[1])
+		return l
+	}
+	return 0
+}
+
+// normalize turns the map[interface{}]interface{} produced by the YAML decoder into map[string]interface{}
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = normalize(e)
+		}
+		return t
+	case []interface{}:
+		for i, e := range t {
+			t[i] = normalize(e)
+		}
+	}
+	return v
+}
+
+// walkKeys records the keys of a decoded document against the configuration type t, like the TOML metadata does
+func walkKeys(doc interface{}, t reflect.Type, path []string, meta *fileMeta) {
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for k, v := range m {
+			p := append(append([]string{}, path...), k)
+			f, ok := t.FieldByNameFunc(func(n string) bool { return strings.EqualFold(n, k) })
+			if !ok {
+				meta.undecoded = append(meta.undecoded, strings.Join(p, "."))
+				continue
+			}
+			meta.define(p)
+			walkKeys(v, f.Type, p, meta)
+		}
+	case reflect.Map:
+		if m, ok := doc.(map[string]interface{}); ok {
+			for k, v := range m {
+				p := append(append([]string{}, path...), k)
+				meta.define(p)
+				walkKeys(v, t.Elem(), p, meta)
+			}
+		}
+	case reflect.Slice:
+		if s, ok := doc.([]interface{}); ok {
+			for _, v := range s {
+				walkKeys(v, t.Elem(), path, meta)
+			}
+		}
+	}
+}
+
+// locator finds the line a dotted key path is defined on
+type locator interface {
+	line(key string) int
+}
+
+// newLocator returns a locator suitable for the format of a configuration file
+func newLocator(format string, data []byte) locator {
+	if format == "toml" {
+		return locateKeys(string(data))
+	}
+	return textLocator(strings.Split(string(data), "\n"))
+}
+
+// textLocator finds keys in YAML and JSON documents by searching for each component of the path in turn, starting
+// from the line of the previous one. Entries of arrays (Table#2) are found by counting the list items that start at the
+// indentation of the first one.
+type textLocator []string
+
+var listItem = regexp.MustCompile(`^(\s*)(-\s|\{)`)
+
+func (t textLocator) line(key string) int {
+	found := 0
+	for _, name := range strings.Split(key, ".") {
+		index := -1
+		if i := strings.Index(name, "#"); i >= 0 {
+			index, _ = strconv.Atoi(name[i+1:])
+			name = name[:i]
+		}
+		re := regexp.MustCompile(`(?i)^\s*(-\s*)?"?` + regexp.QuoteMeta(name) + `"?\s*:`)
+		next := 0
+		for i := found; i < len(t); i++ {
+			if re.MatchString(t[i]) {
+				next = i + 1
+				break
+			}
+		}
+		if next == 0 {
+			return found
+		}
+		found = next
+		if index >= 0 {
+			found = t.item(found, index)
+		}
+	}
+	return found
+}
+
+// item returns the line of entry index of the list following line from, or from if there is no such entry
+func (t textLocator) item(from int, index int) int {
+	indent := ""
+	seen := 0
+	for i := from; i < len(t); i++ {
+		m := listItem.FindStringSubmatch(t[i])
+		if m == nil {
+			continue
+		}
+		if seen == 0 {
+			indent = m[1]
+		} else if m[1] != indent {
+			continue
+		}
+		if seen == index {
+			return i + 1
+		}
+		seen++
+	}
+	return from
+}
@@ -0,0 +1,89 @@
+package conf
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// LoadExternalLabels returns the external labels of c, from the ExternalLabels section and the ExternalLabelsFile.
+// A label defined in both, or with an invalid name, is an error.
+func LoadExternalLabels(c ConfigurationParameters) (map[string]string, error) {
+	defer trace()()
+	labels := make(map[string]string, len(c.ExternalLabels))
+	for k, v := range c.ExternalLabels {
+		labels[k] = v
+	}
+	if c.ExternalLabelsFile != "" {
+		fromFile, err := readLabelsFile(c.ExternalLabelsFile)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fromFile {
+			if _, ok := labels[k]; ok {
+				return nil, fmt.Errorf("external label '%s' is defined both in ExternalLabels and in %s", k, c.ExternalLabelsFile)
+			}
+			labels[k] = v
+		}
+	}
+
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if err := checkLabelName(k); err != nil {
+			return nil, err
+		}
+	}
+	return labels, nil
+}
+
+// checkLabelName reports label names Prometheus would reject or reserves for itself
+func checkLabelName(name string) error {
+	if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("invalid external label name '%s'", name)
+	}
+	return nil
+}
+
+// readLabelsFile reads name=value lines, skipping blank lines and # comments. Values may be double-quoted.
+func readLabelsFile(file string) (map[string]string, error) {
+	defer trace()()
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read external labels file: %s", err)
+	}
+	defer f.Close()
+
+	labels := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected name=value", file, n)
+		}
+		k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if strings.HasPrefix(v, `"`) {
+			if v, err = strconv.Unquote(v); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", file, n, err)
+			}
+		}
+		if _, ok := labels[k]; ok {
+			return nil, fmt.Errorf("%s:%d: label '%s' defined twice", file, n, k)
+		}
+		labels[k] = v
+	}
+	return labels, scanner.Err()
+}
@@ -0,0 +1,36 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLoadExternalLabels(t *testing.T) {
+	f, err := ioutil.TempFile("", "labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# provisioned\nteam = ops\n\ntier=\"web front\"\n")
+	f.Close()
+
+	c := ConfigurationParameters{ExternalLabels: map[string]string{"datacenter": "eu1"}, ExternalLabelsFile: f.Name()}
+	labels, err := LoadExternalLabels(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"datacenter": "eu1", "team": "ops", "tier": "web front"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected %v, got %v", expected, labels)
+	}
+
+	c.ExternalLabels["team"] = "dev"
+	if _, err := LoadExternalLabels(c); err == nil {
+		t.Errorf("expected a label defined twice to be rejected")
+	}
+	if _, err := LoadExternalLabels(ConfigurationParameters{ExternalLabels: map[string]string{"__tmp": "x"}}); err == nil {
+		t.Errorf("expected a reserved label name to be rejected")
+	}
+}
@@ -0,0 +1,337 @@
+package conf
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/djonnala/wmi_exporter/log"
+)
+
+// EnvPrefix starts the name of every environment variable overriding a configuration value, e.g. WMI_EXPORTER_SERVICE_LISTENPORT
+const EnvPrefix = "WMI_EXPORTER_"
+
+// Sources records where each value of UCMConfig came from, keyed by the lower-cased dotted path of the value
+var Sources = map[string]string{}
+
+// Override is a configuration value set on the command line
+type Override struct {
+	// Flag is the command line flag the value came from, for reporting
+	Flag string
+	// Key is the dotted path of the value, e.g. Service.ListenPort
+	Key   string
+	Value string
+}
+
+// Defaults returns the configuration used for anything not set in the file, the environment or on the command line
+func Defaults() ConfigurationParameters {
+	defer trace()()
+	c := ConfigurationParameters{}
+	c.Service.ListenPort = defaultListenPort
+	c.Service.MetricPath = defaultMetricsPath
+	c.Service.ServiceName = "wmi_exporter"
+	c.Collectors.EnabledCollectors = expandCollectors(DefaultPlaceholder, nil)
+	return c
+}
+
+// InitializeFromConfig builds the configuration in layers: defaults, then the configuration file, then WMI_EXPORTER_*
+// environment variables, then command line overrides. Later layers win.
+func InitializeFromConfig(configfile string, overrides ...Override) ConfigurationParameters {
+	defer trace()()
+	c := Defaults()
+	Sources = map[string]string{}
+
+	if configfile != "" {
+		var probe ConfigurationParameters
+		meta, _, err := decodeFile(configfile, mainFormat(configfile), &probe)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// the decoders merge maps into existing ones, so drop the default collectors when the file lists its own
+		if meta.isDefined("Collectors", "EnabledCollectors") {
+			c.Collectors.EnabledCollectors = nil
+		}
+		if _, _, err = decodeFile(configfile, mainFormat(configfile), &c); err != nil {
+			log.Fatal(err)
+		}
+		for k := range meta.defined {
+			Sources[k] = "file " + configfile
+		}
+	}
+
+	if err := mergeFragments(&c, configfile, includeOverride(c.Include, overrides), Sources); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, e := range os.Environ() {
+		kv := strings.SplitN(e, "=", 2)
+		if !strings.HasPrefix(kv[0], EnvPrefix) || len(kv) != 2 {
+			continue
+		}
+		path, ok := envPaths()[strings.TrimPrefix(kv[0], EnvPrefix)]
+		if !ok {
+			log.Warnf("Ignoring environment variable %s, it does not match any configuration value", kv[0])
+			continue
+		}
+		if err := setPath(&c, path, kv[1]); err != nil {
+			log.Fatalf("Cannot apply environment variable %s. Error=%s", kv[0], err)
+		}
+		setSource(c, path, "env "+kv[0])
+	}
+
+	for _, o := range overrides {
+		if err := setPath(&c, o.Key, o.Value); err != nil {
+			log.Fatalf("Cannot apply %s=%s. Error=%s", o.Flag, o.Value, err)
+		}
+		setSource(c, o.Key, "flag "+o.Flag)
+	}
+
+	if err := ResolveSecrets(&c); err != nil {
+		log.Fatalf("Cannot resolve secrets in configuration. Error=%s", err)
+	}
+
+	UCMConfig = c
+	//at this point, conf is a fully loaded configuration now; now initialize everything from conf
+	return UCMConfig
+}
+
+// includeOverride returns the Include pattern in effect, as the environment and flags may replace the one from the file
+// before fragments are merged
+func includeOverride(include string, overrides []Override) string {
+	defer trace()()
+	if v, ok := os.LookupEnv(EnvPrefix + "INCLUDE"); ok {
+		include = v
+	}
+	for _, o := range overrides {
+		if strings.EqualFold(o.Key, "Include") {
+			include = o.Value
+		}
+	}
+	return include
+}
+
+// mergeFragments merges every configuration fragment matching include into c, failing with all conflicts found
+func mergeFragments(c *ConfigurationParameters, configfile string, include string, origin map[string]string) error {
+	defer trace()()
+	files, err := fragmentFiles(configfile, include)
+	if err != nil {
+		return err
+	}
+	var conflicts []string
+	for _, f := range files {
+		var frag ConfigurationParameters
+		meta, _, err := decodeFile(f, formatOf(f), &frag)
+		if err != nil {
+			return err
+		}
+		for _, p := range mergeFragment(c, frag, meta, f, origin) {
+			conflicts = append(conflicts, p.String())
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("Conflicting configuration fragments:\n  %s", strings.Join(conflicts, "\n  "))
+	}
+	return nil
+}
+
+// expandCollectors turns a comma-separated list of collector names into collector specs, expanding DefaultPlaceholder
+// to the default collectors. Collectors already present in current keep their spec.
+func expandCollectors(list string, current map[string]CollectorSpec) map[string]CollectorSpec {
+	defer trace()()
+	m := make(map[string]CollectorSpec)
+	for _, v := range strings.Split(strings.Replace(list, DefaultPlaceholder, defaultCollectors, -1), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if spec, ok := current[v]; ok {
+			m[v] = spec
+		} else {
+			m[v] = CollectorSpec{Namespace: "wmi", DefaultDrop: false}
+		}
+	}
+	return m
+}
+
+// envPaths maps environment variable suffixes (SERVICE_LISTENPORT) to the dotted path of the value they set (Service.ListenPort)
+func envPaths() map[string]string {
+	defer trace()()
+	paths := make(map[string]string)
+	var walk func(t reflect.Type, prefix []string)
+	walk = func(t reflect.Type, prefix []string) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			p := append(append([]string{}, prefix...), f.Name)
+			if f.Type.Kind() == reflect.Struct {
+				walk(f.Type, p)
+				continue
+			}
+			paths[strings.ToUpper(strings.Join(p, "_"))] = strings.Join(p, ".")
+		}
+	}
+	walk(reflect.TypeOf(ConfigurationParameters{}), nil)
+	return paths
+}
+
+// setPath parses raw into the value at the dotted path of c. Field names match case-insensitively, as in the file.
+func setPath(c *ConfigurationParameters, path string, raw string) error {
+	defer trace()()
+	v := reflect.ValueOf(c).Elem()
+	for _, name := range strings.Split(path, ".") {
+		if v.Kind() != reflect.Struct {
+			return fmt.Errorf("%s does not name a single configuration value", path)
+		}
+		f := v.FieldByNameFunc(func(n string) bool { return strings.EqualFold(n, name) })
+		if !f.IsValid() {
+			return fmt.Errorf("unknown configuration key %s", path)
+		}
+		v = f
+	}
+
+	switch {
+	case v.Type() == reflect.TypeOf(map[string]CollectorSpec{}) && !strings.HasPrefix(strings.TrimSpace(raw), "{"):
+		v.Set(reflect.ValueOf(expandCollectors(raw, c.Collectors.EnabledCollectors)))
+		return nil
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(raw), "["):
+		v.Set(reflect.ValueOf(strings.Split(raw, ",")))
+		return nil
+	}
+
+	// anything else is parsed as a TOML value of the field's type
+	holder := reflect.New(reflect.StructOf([]reflect.StructField{{Name: "V", Type: v.Type()}}))
+	if _, err := toml.Decode("V = "+raw, holder.Interface()); err != nil {
+		return err
+	}
+	v.Set(holder.Elem().Field(0))
+	return nil
+}
+
+// sourceOf returns where the value at a dotted path came from. Values no layer set are defaults, even inside a table
+// that a layer set other values of.
+func sourceOf(path []string) string {
+	if s, ok := Sources[strings.ToLower(strings.Join(path, "."))]; ok {
+		return s
+	}
+	return "default"
+}
+
+// setSource records where the value at path came from. Collectors added by a list of names are recorded too, as their
+// tables have no other source.
+func setSource(c ConfigurationParameters, path string, source string) {
+	defer trace()()
+	key := strings.ToLower(path)
+	Sources[key] = source
+	if key != "collectors.enabledcollectors" {
+		return
+	}
+	for name := range c.Collectors.EnabledCollectors {
+		if _, ok := Sources[key+"."+strings.ToLower(name)]; !ok {
+			Sources[key+"."+strings.ToLower(name)] = source
+		}
+	}
+}
+
+// DumpConfig writes the effective configuration in TOML, annotating every value with the layer it came from. Secrets
+// are written as their env: or file: reference.
+func DumpConfig(w io.Writer, c ConfigurationParameters) {
+	defer trace()()
+	dumpTable(w, reflect.ValueOf(c), nil, nil)
+}
+
+// dumpTable writes the plain values of a table first and its sub-tables after them, as TOML requires. path is the
+// dotted name of the table and sourcePath the same with the keys used to look up sources.
+func dumpTable(w io.Writer, v reflect.Value, path []string, sourcePath []string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		if isTable(f.Type()) {
+			continue
+		}
+		sp := append(append([]string{}, sourcePath...), t.Field(i).Name)
+		fmt.Fprintf(w, "%s = %s  # %s\n", t.Field(i).Name, formatValue(f), sourceOf(sp))
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		if !isTable(f.Type()) {
+			continue
+		}
+		p := append(append([]string{}, path...), t.Field(i).Name)
+		sp := append(append([]string{}, sourcePath...), t.Field(i).Name)
+		switch f.Kind() {
+		case reflect.Struct:
+			fmt.Fprintf(w, "\n[%s]\n", strings.Join(p, "."))
+			dumpTable(w, f, p, sp)
+		case reflect.Map:
+			keys := make([]string, 0, f.Len())
+			for _, k := range f.MapKeys() {
+				keys = append(keys, k.String())
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				kp := append(append([]string{}, p...), k)
+				ksp := append(append([]string{}, sp...), k)
+				fmt.Fprintf(w, "\n[%s]  # %s\n", strings.Join(kp, "."), sourceOf(ksp))
+				dumpTable(w, f.MapIndex(reflect.ValueOf(k)), kp, ksp)
+			}
+		case reflect.Slice:
+			for j := 0; j < f.Len(); j++ {
+				fmt.Fprintf(w, "\n[[%s]]  # %s\n", strings.Join(p, "."), sourceOf(sp))
+				dumpTable(w, f.Index(j), p, sp)
+			}
+		}
+	}
+}
+
+// isTable tells whether values of type t are written as TOML tables rather than plain values
+func isTable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct:
+		return true
+	case reflect.Map, reflect.Slice:
+		return t.Elem().Kind() == reflect.Struct
+	}
+	return false
+}
+
+func formatValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		// secrets are shown as the reference they were resolved from
+		if ref, ok := secretRef(v.String()); ok {
+			return strconv.Quote(ref)
+		}
+		return strconv.Quote(v.String())
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = formatValue(v.Index(i))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			items[i] = strconv.Quote(k) + " = " + formatValue(v.MapIndex(reflect.ValueOf(k)))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	case reflect.Float32, reflect.Float64:
+		f := strconv.FormatFloat(v.Float(), 'f', -1, 64)
+		if !strings.Contains(f, ".") {
+			f += ".0"
+		}
+		return f
+	}
+	return fmt.Sprint(v.Interface())
+}
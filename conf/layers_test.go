@@ -0,0 +1,96 @@
+package conf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInitializeFromConfigLayers(t *testing.T) {
+	f, err := ioutil.TempFile("", "wmi_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`[Service]
+    ListenPort = 9000
+    MetricPath = "/file"
+[Collectors.EnabledCollectors.iis]
+    Include = "Default.*"
+`)
+	f.Close()
+	os.Setenv(EnvPrefix+"SERVICE_METRICPATH", "/env")
+	defer os.Unsetenv(EnvPrefix + "SERVICE_METRICPATH")
+	os.Setenv(EnvPrefix+"SERVICE_SERVICENAME", "from-env")
+	defer os.Unsetenv(EnvPrefix + "SERVICE_SERVICENAME")
+
+	c := InitializeFromConfig(f.Name(),
+		Override{Flag: "-telemetry.path", Key: "Service.MetricPath", Value: "/flag"},
+		Override{Flag: "-collectors.enabled", Key: "Collectors.EnabledCollectors", Value: "iis,os"},
+	)
+	if c.Service.ListenPort != 9000 || c.Service.MetricPath != "/flag" || c.Service.ServiceName != "from-env" {
+		t.Errorf("unexpected layered service %+v", c.Service)
+	}
+	if len(c.Collectors.EnabledCollectors) != 2 || c.Collectors.EnabledCollectors["iis"].Include != "Default.*" {
+		t.Errorf("expected the listed collectors to keep their file settings, got %+v", c.Collectors.EnabledCollectors)
+	}
+
+	var dump bytes.Buffer
+	DumpConfig(&dump, c)
+	for _, want := range []string{
+		"ListenPort = 9000  # file " + f.Name(),
+		`MetricPath = "/flag"  # flag -telemetry.path`,
+		`ServiceName = "from-env"  # env WMI_EXPORTER_SERVICE_SERVICENAME`,
+		// the file set other values of the table, not this one
+		`ListenIP = ""  # default`,
+		"[Collectors.EnabledCollectors.iis]  # file " + f.Name(),
+		`Include = "Default.*"  # file ` + f.Name(),
+		`Exclude = ""  # default`,
+		"[Collectors.EnabledCollectors.os]  # flag -collectors.enabled",
+	} {
+		if !strings.Contains(dump.String(), want+"\n") {
+			t.Errorf("expected %q in the dump\n%s", want, dump.String())
+		}
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	var c ConfigurationParameters
+	for path, raw := range map[string]string{
+		"service.listenport":           "9100",
+		"Service.MetricPath":           "/metrics",
+		"ExternalLabels":               `{ team = "ops" }`,
+		"AwsTagsToLabels.Enabled":      "true",
+		"MetadataReporting.Attributes": `[{ TagName = ["region"], LabelName = "region" }]`,
+		"Collectors.EnabledCollectors": "cpu,os",
+	} {
+		if err := setPath(&c, path, raw); err != nil {
+			t.Errorf("cannot set %s: %s", path, err)
+		}
+	}
+	if c.Service.ListenPort != 9100 || c.Service.MetricPath != "/metrics" || !c.AwsTagsToLabels.Enabled {
+		t.Errorf("unexpected configuration %+v", c)
+	}
+	if !reflect.DeepEqual(c.ExternalLabels, map[string]string{"team": "ops"}) {
+		t.Errorf("unexpected external labels %v", c.ExternalLabels)
+	}
+	if len(c.MetadataReporting.Attributes) != 1 || c.MetadataReporting.Attributes[0].LabelName != "region" {
+		t.Errorf("unexpected attributes %+v", c.MetadataReporting.Attributes)
+	}
+	if len(c.Collectors.EnabledCollectors) != 2 || c.Collectors.EnabledCollectors["os"].Namespace != "wmi" {
+		t.Errorf("unexpected collectors %+v", c.Collectors.EnabledCollectors)
+	}
+
+	for path, raw := range map[string]string{
+		"Service.Bogus":      "1",
+		"Service.ListenPort": "many",
+		"Service.ListenIP.x": "1",
+	} {
+		if err := setPath(&c, path, raw); err == nil {
+			t.Errorf("expected setting %s to %s to fail", path, raw)
+		}
+	}
+}
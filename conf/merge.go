@@ -0,0 +1,147 @@
+package conf
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// fragmentFiles resolves the Include glob, relative to the directory of the main configuration file, into a sorted
+// list of configuration fragments in any supported format
+func fragmentFiles(configfile string, include string) ([]string, error) {
+	defer trace()()
+	if include == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(include) && configfile != "" {
+		include = filepath.Join(filepath.Dir(configfile), include)
+	}
+	matches, err := filepath.Glob(include)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Include pattern %s: %s", include, err)
+	}
+	var files []string
+	for _, f := range matches {
+		if isConfigFile(f) {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// fragmentMerger merges configuration fragments into a configuration. origin holds the file each value came from,
+// keyed by lower-cased dotted path, so that conflicts can name both files involved.
+type fragmentMerger struct {
+	origin    map[string]string
+	file      string
+	defined   map[string]bool
+	conflicts []Problem
+	// override replaces values and arrays of tables instead of reporting conflicts and appending
+	override bool
+}
+
+// mergeFragment merges a decoded fragment into c. Tables merge by key, arrays of tables are appended and plain values
+// may only be set once across files. All conflicts found are returned together.
+func mergeFragment(c *ConfigurationParameters, frag ConfigurationParameters, meta fileMeta, file string, origin map[string]string) []Problem {
+	defer trace()()
+	m := &fragmentMerger{origin: origin, file: file, defined: meta.defined}
+	m.mergeStruct(reflect.ValueOf(c).Elem(), reflect.ValueOf(frag), nil)
+	return m.conflicts
+}
+
+func (m *fragmentMerger) isDefined(path []string) bool {
+	return m.defined[strings.ToLower(strings.Join(path, "."))]
+}
+
+func (m *fragmentMerger) setOrigin(path []string) {
+	m.origin[strings.ToLower(strings.Join(path, "."))] = "file " + m.file
+}
+
+func (m *fragmentMerger) conflict(path []string, format string, args ...interface{}) {
+	key := strings.Join(path, ".")
+	m.conflicts = append(m.conflicts, Problem{File: m.file, key: key, Message: key + ": " + fmt.Sprintf(format, args...)})
+}
+
+func (m *fragmentMerger) mergeStruct(dst, src reflect.Value, path []string) {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		p := append(append([]string{}, path...), t.Field(i).Name)
+		if !m.isDefined(p) {
+			continue
+		}
+		m.mergeValue(dst.Field(i), src.Field(i), p)
+	}
+}
+
+func (m *fragmentMerger) mergeValue(dst, src reflect.Value, path []string) {
+	switch {
+	case dst.Kind() == reflect.Struct:
+		m.mergeStruct(dst, src, path)
+	case dst.Kind() == reflect.Map:
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+		for _, k := range src.MapKeys() {
+			kp := append(append([]string{}, path...), k.String())
+			existing := dst.MapIndex(k)
+			if !existing.IsValid() {
+				dst.SetMapIndex(k, src.MapIndex(k))
+				m.setOrigin(kp)
+				continue
+			}
+			// map values are not addressable, so merge into a copy and store it back
+			merged := reflect.New(existing.Type()).Elem()
+			merged.Set(existing)
+			m.mergeValue(merged, src.MapIndex(k), kp)
+			dst.SetMapIndex(k, merged)
+		}
+	case dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Struct && m.override:
+		dst.Set(src)
+		m.setOrigin(path)
+	case dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Struct:
+		seen := make(map[string]bool)
+		for i := 0; i < dst.Len(); i++ {
+			seen[identity(dst.Index(i))] = true
+		}
+		for i := 0; i < src.Len(); i++ {
+			id := identity(src.Index(i))
+			if id != "" && seen[id] {
+				m.conflict(path, "duplicate entry '%s', already defined in %s", id, m.originOf(path))
+				continue
+			}
+			seen[id] = true
+			dst.Set(reflect.Append(dst, src.Index(i)))
+		}
+		m.setOrigin(path)
+	default:
+		if prev := m.originOf(path); !m.override && strings.HasPrefix(prev, "file ") && !reflect.DeepEqual(dst.Interface(), src.Interface()) {
+			m.conflict(path, "set to %v, but already set to %v in %s", src.Interface(), dst.Interface(), strings.TrimPrefix(prev, "file "))
+			return
+		}
+		dst.Set(src)
+		m.setOrigin(path)
+	}
+}
+
+// originOf returns where the value at path, or its closest enclosing table, was defined
+func (m *fragmentMerger) originOf(path []string) string {
+	for i := len(path); i > 0; i-- {
+		if s, ok := m.origin[strings.ToLower(strings.Join(path[:i], "."))]; ok {
+			return s
+		}
+	}
+	return "default"
+}
+
+// identity names an entry of an array of tables for duplicate detection, by ExportName or LabelName
+func identity(v reflect.Value) string {
+	for _, name := range []string{"ExportName", "LabelName"} {
+		if f := v.FieldByName(name); f.IsValid() && f.Kind() == reflect.String {
+			return f.String()
+		}
+	}
+	return ""
+}
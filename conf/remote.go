@@ -0,0 +1,50 @@
+package conf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/djonnala/wmi_exporter/log"
+)
+
+// MergeRemote merges the Collectors table of a TOML document read from origin, e.g. a Consul KV key, over c and
+// returns the result. Values of the document override those of c, and other tables of the document are ignored. c is
+// left unchanged, so that each version of the document can be merged over the same local configuration.
+func MergeRemote(c ConfigurationParameters, data []byte, origin string) (ConfigurationParameters, error) {
+	defer trace()()
+	var doc ConfigurationParameters
+	meta, err := decodeData(origin, "toml", data, &doc)
+	if err != nil {
+		return c, err
+	}
+	if len(meta.undecoded) > 0 {
+		sort.Strings(meta.undecoded)
+		return c, fmt.Errorf("%s: unknown keys %s", origin, strings.Join(meta.undecoded, ", "))
+	}
+
+	defined := make(map[string]bool)
+	ignored := make(map[string]bool)
+	for k := range meta.defined {
+		if k == "collectors" || strings.HasPrefix(k, "collectors.") {
+			defined[k] = true
+		} else {
+			ignored[strings.SplitN(k, ".", 2)[0]] = true
+		}
+	}
+	for k := range ignored {
+		log.Warnf("Ignoring %s in %s, only the Collectors table is read from there", k, origin)
+	}
+
+	// copy the collector specs, the running configuration must not change under the collectors
+	specs := make(map[string]CollectorSpec, len(c.Collectors.EnabledCollectors))
+	for k, v := range c.Collectors.EnabledCollectors {
+		specs[k] = v
+	}
+	c.Collectors.EnabledCollectors = specs
+
+	m := &fragmentMerger{origin: make(map[string]string), file: origin, defined: defined, override: true}
+	m.mergeValue(reflect.ValueOf(&c.Collectors).Elem(), reflect.ValueOf(doc.Collectors), []string{"Collectors"})
+	return c, nil
+}
@@ -0,0 +1,106 @@
+package conf
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMergeRemote(t *testing.T) {
+	local := ConfigurationParameters{Title: "local"}
+	local.Collectors.EnabledCollectors = map[string]CollectorSpec{
+		"cpu": {},
+		"iis": {Include: "Default.*", ExportedMetrics: []MetricMap{{ExportName: "a"}}},
+	}
+	doc := `
+Title = "remote"
+[Collectors.EnabledCollectors.iis]
+    Exclude = "Test.*"
+    [[Collectors.EnabledCollectors.iis.ExportedMetrics]]
+        ExportName = "b"
+[Collectors.EnabledCollectors.os]
+    Include = "file:C:/Windows/win.ini"
+`
+	c, err := MergeRemote(local, []byte(doc), "wmi_exporter/dc1/web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "local" {
+		t.Errorf("expected tables other than Collectors to be ignored, got Title %q", c.Title)
+	}
+	iis := c.Collectors.EnabledCollectors["iis"]
+	if iis.Include != "Default.*" || iis.Exclude != "Test.*" || len(iis.ExportedMetrics) != 1 || iis.ExportedMetrics[0].ExportName != "b" {
+		t.Errorf("unexpected merged iis collector %+v", iis)
+	}
+	if os, ok := c.Collectors.EnabledCollectors["os"]; !ok || len(c.Collectors.EnabledCollectors) != 3 {
+		t.Errorf("expected the os collector to be added, got %v", c.Collectors.EnabledCollectors)
+	} else if os.Include != "file:C:/Windows/win.ini" {
+		t.Errorf("expected references in the document to be taken literally, got %q", os.Include)
+	}
+	if len(local.Collectors.EnabledCollectors) != 2 || local.Collectors.EnabledCollectors["iis"].Exclude != "" {
+		t.Errorf("expected the local configuration to be left unchanged")
+	}
+
+	if _, err := MergeRemote(local, []byte("[Collectors]\nBogus = 1\n"), "key"); err == nil {
+		t.Errorf("expected unknown keys to be rejected")
+	}
+}
+
+func TestValidateRemoteCollectors(t *testing.T) {
+	var local ConfigurationParameters
+	doc := `
+[Collectors.EnabledCollectors.iis]
+    Include = "("
+    [[Collectors.EnabledCollectors.iis.ExportedMetrics]]
+        ExportName = "hits"
+        SourceName = ["Hits"]
+        ComputedMetric = true
+        ComputeLogic = "bogus("
+`
+	c, err := MergeRemote(local, []byte(doc), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate := func(expr string) error {
+		if strings.HasSuffix(expr, "(") {
+			return errors.New("unbalanced parenthesis")
+		}
+		return nil
+	}
+	err = ValidateCollectors(c, validate, nil)
+	if err == nil {
+		t.Fatalf("expected the merged document to be rejected")
+	}
+	for _, want := range []string{"invalid Include pattern for collector 'iis'", "invalid ComputeLogic for metric 'hits': unbalanced parenthesis"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %q", want, err)
+		}
+	}
+
+	c.Collectors.EnabledCollectors["iis"] = CollectorSpec{ExportedMetrics: []MetricMap{{ExportName: "hits", SourceName: []string{"Hits"}}}}
+	if err := ValidateCollectors(c, validate, nil); err != nil {
+		t.Errorf("expected a valid document to pass, got %s", err)
+	}
+
+	c.Collectors.EnabledCollectors["iis"] = CollectorSpec{ExportedMetrics: []MetricMap{
+		{ExportName: "hits"},
+		{ExportName: "rate", ComputedMetric: true, ComputeLogic: "1"},
+		{ExportName: "info", MetricType: "Info"},
+		{ExportName: "state", MetricType: "StateSet", States: []string{"up"}},
+	}}
+	err = ValidateCollectors(c, validate, nil)
+	if err == nil || strings.Count(err.Error(), "has no SourceName") != 2 || !strings.Contains(err.Error(), "'hits'") || !strings.Contains(err.Error(), "'state'") {
+		t.Errorf("expected only the metrics reading SourceName to need one, got %v", err)
+	}
+	delete(c.Collectors.EnabledCollectors, "iis")
+
+	numeric := func(collector string, source string) bool { return source != "Caption" }
+	c.Collectors.EnabledCollectors["tos"] = CollectorSpec{ExportedMetrics: []MetricMap{
+		{ExportName: "caption", SourceName: []string{"Caption"}},
+		{ExportName: "info", MetricType: "Info", SourceName: []string{"Caption"}},
+	}}
+	err = ValidateCollectors(c, validate, numeric)
+	if err == nil || !strings.Contains(err.Error(), "exported metric 'caption' of collector 'tos' reads string property 'Caption'") || strings.Contains(err.Error(), "'info'") {
+		t.Errorf("expected only the Gauge reading a string to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package conf
+
+//go:generate go run ../tools/schema-docs/generate-docs.go -o schema_docs.go config.go
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// SchemaID identifies the JSON Schema of the configuration format
+const SchemaID = "https://github.com/djonnala/wmi_exporter/config.schema.json"
+
+// enums lists the accepted values of fields restricted to a fixed set
+var enums = map[string]map[string]bool{
+	"MetricMap.MetricType":     metricTypes,
+	"RelabelConfig.Action":     relabelActions,
+	"ConsulConf.Backend":       discoveryBackends,
+	"ConsulConf.Scheme":        consulSchemes,
+	"ConsulConf.Mode":          consulModes,
+	"ConsulConf.CheckType":     consulCheckTypes,
+	"ConsulConf.CheckScheme":   consulSchemes,
+	"MetaDataConf.Provider":    metadataProviders,
+	"MetaDataConf.IMDSVersion": imdsVersions,
+	"LabelConf.TagSource":      tagSources,
+}
+
+// Schema returns a JSON Schema (draft-07) of ConfigurationParameters. Descriptions come from the doc comments of the
+// configuration types and every collector named gets its own section under Collectors.EnabledCollectors. Property names
+// are matched exactly, as JSON Schema does, while the exporter matches keys case-insensitively in every format: the
+// schema rejects e.g. listenport, which the exporter accepts as ListenPort.
+func Schema(collectors []string) map[string]interface{} {
+	defer trace()()
+	definitions := make(map[string]interface{})
+	schemaOf(reflect.TypeOf(ConfigurationParameters{}), definitions)
+	// the root is the definition of ConfigurationParameters itself, so that validators apply it
+	schema := definitions["ConfigurationParameters"].(map[string]interface{})
+	delete(definitions, "ConfigurationParameters")
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["$id"] = SchemaID
+	schema["title"] = "wmi_exporter configuration"
+	schema["description"] = "Property names are case-sensitive here, use the casing of the schema even where the exporter accepts any"
+	schema["definitions"] = definitions
+
+	sort.Strings(collectors)
+	sections := make(map[string]interface{}, len(collectors))
+	for _, name := range collectors {
+		sections[name] = map[string]interface{}{
+			"description": "Options of the " + name + " collector",
+			"$ref":        "#/definitions/CollectorSpec",
+		}
+	}
+	enabled := definitions["CollectorConf"].(map[string]interface{})["properties"].(map[string]interface{})["EnabledCollectors"].(map[string]interface{})
+	enabled["properties"] = sections
+	return schema
+}
+
+// WriteSchema writes the JSON Schema of the configuration, indented
+func WriteSchema(w io.Writer, collectors []string) error {
+	defer trace()()
+	b, err := json.MarshalIndent(Schema(collectors), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// schemaOf returns the schema of a type, adding the definitions of the struct types it uses
+func schemaOf(t reflect.Type, definitions map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Struct:
+		if _, ok := definitions[t.Name()]; !ok {
+			def := map[string]interface{}{"type": "object", "additionalProperties": false}
+			// register before walking the fields, in case of recursive types
+			definitions[t.Name()] = def
+			if d, ok := fieldDocs[t.Name()]; ok {
+				def["description"] = d
+			}
+			properties := make(map[string]interface{}, t.NumField())
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				p := schemaOf(f.Type, definitions)
+				key := t.Name() + "." + f.Name
+				if d, ok := fieldDocs[key]; ok {
+					p["description"] = d
+				}
+				if values, ok := enums[key]; ok {
+					var e []string
+					for v := range values {
+						e = append(e, v)
+					}
+					sort.Strings(e)
+					p["enum"] = e
+				}
+				properties[f.Name] = p
+			}
+			def["properties"] = properties
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), definitions)}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), definitions)}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{"type": "string"}
+}
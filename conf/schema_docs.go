@@ -0,0 +1,123 @@
+// Code generated by tools/schema-docs from config.go; DO NOT EDIT.
+
+package conf
+
+// fieldDocs holds the doc comments of the configuration types and their fields, keyed by Type or Type.Field
+var fieldDocs = map[string]string{
+	"CollectorCondition":                           "CollectorCondition restricts a collector to hosts with matching attributes. Every condition set must hold, and a collector without conditions runs on every host.",
+	"CollectorCondition.Hostname":                  "Hostname is a regular expression the host name must match, ignoring case",
+	"CollectorCondition.Service":                   "Service names a Windows service that must be installed on the host, e.g. DNS",
+	"CollectorCondition.Tags":                      "Tags maps instance tags, or instance attributes such as region, to regular expressions their values must match",
+	"CollectorCondition.WmiClass":                  "WmiClass names a WMI class that must exist on the host, e.g. Win32_PerfRawData_W3SVC_WebService",
+	"CollectorConf":                                "CollectorConf captures the list of collectors to use",
+	"CollectorConf.AgentCollectionEnabled":         "AgentCollectionEnabled is reserved for exposing agent metrics",
+	"CollectorConf.EnabledCollectors":              "EnabledCollectors holds the options of each collector to run, keyed by collector name",
+	"CollectorConf.ExporterCollectionEnabled":      "ExporterCollectionEnabled is reserved for exposing exporter metrics",
+	"CollectorConf.GoCollectionEnabled":            "GoCollectionEnabled is reserved for exposing Go runtime metrics",
+	"CollectorConf.MetricTimeout":                  "MetricTimeout is reserved for limiting the time a collection may take",
+	"CollectorConf.WmiCollectionEnabled":           "WmiCollectionEnabled is reserved for enabling WMI collection as a whole",
+	"CollectorSpec":                                "CollectorSpec captures the options of a single collector",
+	"CollectorSpec.DefaultDrop":                    "DefaultDrop drops the built-in metrics of a templated collector, keeping only its ExportedMetrics",
+	"CollectorSpec.Exclude":                        "Exclude is a regular expression on the instance label of the collector. Matching instances are not reported.",
+	"CollectorSpec.ExportedMetrics":                "ExportedMetrics are the metrics a templated collector exports from its source values",
+	"CollectorSpec.Include":                        "Include is a regular expression on the instance label of the collector (site, volume, nic, core or service name). Only matching instances are reported.",
+	"CollectorSpec.Namespace":                      "Namespace prefixes the names of the exported metrics of templated collectors",
+	"CollectorSpec.SampleInterval":                 "SampleInterval, in seconds, samples numeric exported metrics between scrapes and exposes their _min, _max, _avg and _last",
+	"CollectorSpec.When":                           "When restricts the collector to hosts matching a condition, so that one configuration serves different fleets",
+	"ConfigurationParameters":                      "ConfigurationParameters provides the struct to hold configuration parameters from config file",
+	"ConfigurationParameters.AwsTagsToLabels":      "AwsTagsToLabels captures the aws tags that should be added to reported metrics as Labels",
+	"ConfigurationParameters.Collectors":           "Collectors captures the list of collectors to use",
+	"ConfigurationParameters.ExternalLabels":       "ExternalLabels are constant labels attached to every metric of every collector",
+	"ConfigurationParameters.ExternalLabelsFile":   "ExternalLabelsFile names a file of name=value lines adding to ExternalLabels, e.g. written by provisioning",
+	"ConfigurationParameters.Include":              "Include is a glob of configuration fragments, relative to this file, merged into it in lexical order",
+	"ConfigurationParameters.MetadataReporting":    "MetadataReporting captures which metadata to be registered with service into consul for use during discovery",
+	"ConfigurationParameters.MetricRelabelConfigs": "MetricRelabelConfigs rewrite or drop the series of every collector before exposition, in order",
+	"ConfigurationParameters.RemoteConfig":         "RemoteConfig reads collector settings from Consul KV, merged over this file and followed for changes",
+	"ConfigurationParameters.Service":              "Service captures agent related configurations",
+	"ConfigurationParameters.ServiceDiscovery":     "ServiceDiscovery captures configuration parameters needed for service discovery registration with Consul",
+	"ConfigurationParameters.Title":                "Title names the configuration",
+	"ConsulConf":                                   "ConsulConf captures configuration parameters needed for service discovery registration with Consul",
+	"ConsulConf.AddressCIDR":                       "AddressCIDR restricts the registered address to a network, e.g. 10.0.0.0/8 or 2001:db8::/32",
+	"ConsulConf.AddressFromRoute":                  "AddressFromRoute registers the local address of the route to RemoteEndpoint, the one Consul sees the host from. It fails when that is a loopback or link-local address, e.g. with a local Consul agent",
+	"ConsulConf.AddressInterface":                  "AddressInterface restricts the registered address to the interface of that name, e.g. Ethernet0",
+	"ConsulConf.Backend":                           "Backend is consul (the default) or file_sd, writing a Prometheus file_sd target file to FileSDPath instead",
+	"ConsulConf.CAFile":                            "CaFile is a PEM file of the certificate authorities trusted for the Consul server, the system roots when empty",
+	"ConsulConf.CertFile":                          "CertFile is the PEM client certificate presented to Consul, for servers verifying clients",
+	"ConsulConf.CheckInterval":                     "CheckInterval, in seconds, is how often the agent polls /health, 10 by default",
+	"ConsulConf.CheckScheme":                       "CheckScheme is the scheme of the /health URL the agent polls, http (the default) or https, e.g. behind a proxy terminating TLS in front of the exporter",
+	"ConsulConf.CheckTTL":                          "CheckTTL, in seconds, is how long a ttl check stays passing without the exporter reporting in, 30 by default",
+	"ConsulConf.CheckTimeout":                      "CheckTimeout, in seconds, bounds a /health request of the agent, 5 by default",
+	"ConsulConf.CheckType":                         "CheckType is http (the default), with the agent polling /health, or ttl, with the exporter reporting in itself",
+	"ConsulConf.Datacenter":                        "Datacenter is the Consul datacenter to register in",
+	"ConsulConf.DeregisterCriticalServiceAfter":    "DeregisterCriticalServiceAfter, in seconds, has the agent remove the service once its check failed that long, so that crashed exporters do not linger; Consul enforces at least a minute. 0 keeps failed services registered.",
+	"ConsulConf.Enabled":                           "Enabled registers the exporter with Consul at startup and deregisters it on shutdown",
+	"ConsulConf.FileSDPath":                        "FileSDPath is the target file written by the file_sd backend, e.g. on a share Prometheus reads from. It is removed on shutdown.",
+	"ConsulConf.InsecureSkipVerify":                "InsecureSkipVerify disables the verification of the Consul server certificate, for testing only",
+	"ConsulConf.KeyFile":                           "KeyFile is the PEM private key of CertFile",
+	"ConsulConf.Mode":                              "Mode is catalog (the default), writing a catalog entry that stays passing, or agent, registering through the Consul agent at RemoteEndpoint with a health check. Agents run the checks of the services registered with them, so RemoteEndpoint has to be the address of the agent on this host.",
+	"ConsulConf.Namespace":                         "Namespace is the Consul Enterprise namespace the service is registered in",
+	"ConsulConf.Partition":                         "Partition is the Consul Enterprise admin partition the service is registered in",
+	"ConsulConf.RefreshInterval":                   "RefreshInterval, in seconds, is how often the exporter checks that it is still registered with unchanged tags and registers again otherwise, 60 by default",
+	"ConsulConf.RegisterServiceName":               "RegisterServiceName is the service name registered with Consul",
+	"ConsulConf.RemoteEndpoint":                    "RemoteEndpoint is the host name or IP of the Consul server",
+	"ConsulConf.RemotePort":                        "RemotePort is the HTTP port of the Consul server",
+	"ConsulConf.Scheme":                            "Scheme is http (the default) or https, verifying the server certificate against CAFile",
+	"ConsulConf.ServiceID":                         "ServiceID identifies the registered service instance",
+	"ConsulConf.TLSServerName":                     "TlsServerName is the name the Consul server certificate is verified against, RemoteEndpoint when empty",
+	"ConsulConf.Token":                             "Token is the ACL token sent with every Consul request, best given as an env: or file: reference",
+	"ConsulConf.TokenFile":                         "TokenFile names a file holding the ACL token, read on every registration and taking precedence over Token",
+	"LabelConf":                                    "LabelConf captures the aws tags that should be added to reported metrics as Labels",
+	"LabelConf.Enabled":                            "Enabled adds the instance tags, AWS and Azure tags or GCE labels, as labels to the metrics of templated collectors",
+	"LabelConf.RefreshPeriod":                      "RefreshPeriod, in seconds, is how often the instance tags are fetched again",
+	"LabelConf.TagSource":                          "TagSource is where AWS tags come from: imds, the tags/instance endpoint of the instance metadata service, which needs instance metadata tags enabled on the instance; api, ec2:DescribeTags, which needs IAM permissions and access to the EC2 API; or auto (the default), the metadata service then the API",
+	"LabelConf.TagsToCapture":                      "TagsToCapture is not read: the labels added are mapped from the instance tags by MetadataReporting.Attributes",
+	"MetaDataConf":                                 "MetaDataConf captures which metadata to be registered with service into consul for use during discovery",
+	"MetaDataConf.AWSRegion":                       "AwsRegion is the AWS region used to look up instance tags, the region of the instance when empty",
+	"MetaDataConf.Attributes":                      "Attributes maps instance tags to the service metadata reported, with keys sanitized to what Consul accepts",
+	"MetaDataConf.ConnectTimeout":                  "ConnectTimeout, in seconds, bounds connecting to the metadata service, 1 by default",
+	"MetaDataConf.Enabled":                         "Enabled reports AWS instance tags as service metadata when registering with Consul",
+	"MetaDataConf.HopLimit":                        "HopLimit is the IP TTL of the requests sent to the metadata service, the system default when 0. The hop limit of the token responses is an instance setting, HttpPutResponseHopLimit.",
+	"MetaDataConf.IMDSVersion":                     "ImdsVersion is v2 (the default), requesting a session token first as hardened instances require, v1 without token, or auto, falling back to v1 when no token can be had",
+	"MetaDataConf.LegacyTags":                      "LegacyTags reports Attributes as key=value; service tags instead of service metadata",
+	"MetaDataConf.MetadataURL":                     "MetadataURL is the base URL of the instance metadata service, by default http://169.254.169.254 on AWS and Azure and http://metadata.google.internal on GCE, e.g. a local stand-in for testing",
+	"MetaDataConf.Provider":                        "Provider is the cloud whose metadata service describes the host: aws, azure, gce, none, or auto (the default), trying each in that order",
+	"MetaDataConf.Timeout":                         "Timeout, in seconds, bounds each metadata request as a whole, 5 by default",
+	"MetaDataConf.TokenTTL":                        "TokenTTL, in seconds, is the lifetime of IMDSv2 session tokens, 21600 by default",
+	"MetricMap":                                    "MetricMap captures a mapping between one or more WMI metrics and the name it should be reported with",
+	"MetricMap.Buckets":                            "Buckets are the upper bounds of the buckets of a Histogram",
+	"MetricMap.ComputeLogic":                       "ComputeLogic is the expression computing the value of a computed metric",
+	"MetricMap.ComputedMetric":                     "ComputedMetric computes the value with ComputeLogic instead of taking the first SourceName",
+	"MetricMap.Desc":                               "Desc is added to the help of the metric",
+	"MetricMap.ExportName":                         "ExportName is the name of the metric, unique within the collector",
+	"MetricMap.MetricType":                         "MetricType is Gauge (the default), Counter, Histogram, Summary, Info or StateSet",
+	"MetricMap.Objectives":                         "Objectives are the quantiles of a Summary",
+	"MetricMap.SourceName":                         "SourceName lists the source values the metric is built from",
+	"MetricMap.States":                             "States are the values a StateSet metric reports one series for",
+	"Objective":                                    "Objective captures a quantile and its allowed error for metrics exported with MetricType Summary",
+	"Objective.Error":                              "Error is the allowed absolute error of the quantile",
+	"Objective.Quantile":                           "Quantile is the quantile to report, between 0 and 1",
+	"RelabelConfig":                                "RelabelConfig captures a relabeling rule with the semantics of a Prometheus metric_relabel_configs entry. Separator defaults to \";\", Regex to \"(.*)\", Replacement to \"$1\" and Action to \"replace\".",
+	"RelabelConfig.Action":                         "Action is one of replace, keep, drop, hashmod, labelmap, labeldrop and labelkeep",
+	"RelabelConfig.Modulus":                        "Modulus is the modulus of the hash for the hashmod action",
+	"RelabelConfig.Regex":                          "Regex is matched against the joined source values, or the label names for labelmap, labeldrop and labelkeep",
+	"RelabelConfig.Replacement":                    "Replacement is written to TargetLabel, with $1 and ${name} referring to groups of Regex",
+	"RelabelConfig.Separator":                      "Separator joins the values of SourceLabels",
+	"RelabelConfig.SourceLabels":                   "SourceLabels are the labels whose values, joined by Separator, are matched against Regex",
+	"RelabelConfig.TargetLabel":                    "TargetLabel is the label written by the replace and hashmod actions",
+	"RemoteConfigConf":                             "RemoteConfigConf captures a configuration document in Consul KV. Its Collectors table is merged over the one of the configuration file, overriding it, and the collectors are rebuilt whenever the document changes.",
+	"RemoteConfigConf.CacheFile":                   "CacheFile keeps the last document read, used when Consul cannot be reached at startup",
+	"RemoteConfigConf.Enabled":                     "Enabled reads the document at startup and watches it, using the connection settings of ServiceDiscovery",
+	"RemoteConfigConf.Key":                         "Key is the KV key of the TOML document. {datacenter}, {hostname} and {tag:NAME} are replaced with the ServiceDiscovery datacenter, the host name and the value of a tag of the instance, e.g. wmi_exporter/{datacenter}/{tag:role}.",
+	"RemoteConfigConf.WaitTime":                    "WaitTime, in seconds, bounds each blocking query watching the key, 300 by default",
+	"ServiceConf":                                  "ServiceConf captures agent related configurations",
+	"ServiceConf.CollectionInterval":               "CollectionInterval is reserved for scheduling collections",
+	"ServiceConf.ListenIP":                         "ListenIP is the address the HTTP server binds to, all addresses when empty",
+	"ServiceConf.ListenPort":                       "ListenPort is the port the HTTP server listens on",
+	"ServiceConf.MetricPath":                       "MetricPath is the URL path metrics are exposed on",
+	"ServiceConf.ServiceName":                      "ServiceName is the name of the Windows service the exporter runs as",
+	"TagLabelMap":                                  "TagLabelMap captures a mapping between one or more WMI metrics and the name it should be reported with",
+	"TagLabelMap.LabelName":                        "LabelName is the name of the label reported",
+	"TagLabelMap.MergeSeparator":                   "MergeSeparator joins the values of several tags",
+	"TagLabelMap.MissingLabel":                     "MissingLabel is the value used for a tag the instance does not have",
+	"TagLabelMap.TagName":                          "TagName lists the instance tags whose values make up the label",
+}
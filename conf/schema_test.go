@@ -0,0 +1,39 @@
+package conf
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSchema(&buf, []string{"cpu", "tcpu"}); err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties  map[string]map[string]interface{}
+		Definitions map[string]struct {
+			AdditionalProperties bool
+			Properties           map[string]map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	if schema.Properties["Service"]["$ref"] != "#/definitions/ServiceConf" {
+		t.Errorf("expected Service to refer to ServiceConf, got %v", schema.Properties["Service"])
+	}
+	labels := schema.Definitions["LabelConf"]
+	if labels.AdditionalProperties || labels.Properties["TagsToCapture"] == nil || labels.Properties["TagsToCaptue"] != nil {
+		t.Errorf("expected LabelConf to only accept its fields, got %+v", labels)
+	}
+	if d := labels.Properties["RefreshPeriod"]["description"]; d != fieldDocs["LabelConf.RefreshPeriod"] || d == nil {
+		t.Errorf("expected the doc comment of RefreshPeriod as description, got %v", d)
+	}
+	sections := schema.Definitions["CollectorConf"].Properties["EnabledCollectors"]["properties"].(map[string]interface{})
+	if len(sections) != 2 || sections["tcpu"] == nil {
+		t.Errorf("expected a section per collector, got %v", sections)
+	}
+}
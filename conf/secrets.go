@@ -0,0 +1,145 @@
+package conf
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/djonnala/wmi_exporter/log"
+)
+
+// Redacted replaces resolved secrets in logs
+const Redacted = "<redacted>"
+
+// secretReference matches configuration strings naming a secret instead of holding it: env:NAME reads an environment
+// variable and file:PATH the content of a file, without its trailing line break
+var secretReference = regexp.MustCompile(`^(env:[A-Za-z_][A-Za-z0-9_]*|file:.+)$`)
+
+var (
+	secretsMtx sync.RWMutex
+	// secretRefs maps every secret resolved so far to the reference it was resolved from. Secrets of earlier loads are
+	// kept, as they may still show up in logs after a reload.
+	secretRefs = map[string]string{}
+)
+
+// secretFields are the credential fields of the configuration which may hold env: and file: references, by path.
+// Other values are taken literally.
+var secretFields = map[string]func(c *ConfigurationParameters) *string{
+	"ServiceDiscovery.Token": func(c *ConfigurationParameters) *string { return &c.ServiceDiscovery.Token },
+}
+
+// ResolveSecrets replaces env: and file: references in the credential fields of c with the secret they name. It is
+// called by InitializeFromConfig and has to be called again on anything reloading the configuration file.
+func ResolveSecrets(c *ConfigurationParameters) error {
+	defer trace()()
+	paths := make([]string, 0, len(secretFields))
+	for path := range secretFields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var errs []string
+	for _, path := range paths {
+		field := secretFields[path](c)
+		if resolved, ok := resolveSecret(*field, path, &errs); ok {
+			*field = resolved
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// resolveSecret returns the secret a reference names, or false if s is no reference
+func resolveSecret(s string, path string, errs *[]string) (string, bool) {
+	if !secretReference.MatchString(s) {
+		return "", false
+	}
+	var secret string
+	if strings.HasPrefix(s, "env:") {
+		v, ok := os.LookupEnv(strings.TrimPrefix(s, "env:"))
+		if !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: environment variable %s is not set", path, strings.TrimPrefix(s, "env:")))
+			return "", false
+		}
+		secret = v
+	} else {
+		b, err := ioutil.ReadFile(strings.TrimPrefix(s, "file:"))
+		if err != nil {
+			*errs = append(*errs, fmt.Sprintf("%s: cannot read secret: %s", path, err))
+			return "", false
+		}
+		secret = strings.TrimRight(string(b), "\r\n")
+	}
+	if secret != "" {
+		secretsMtx.Lock()
+		secretRefs[secret] = s
+		secretsMtx.Unlock()
+	}
+	return secret, true
+}
+
+// secretRef returns the reference a resolved secret came from
+func secretRef(s string) (string, bool) {
+	secretsMtx.RLock()
+	defer secretsMtx.RUnlock()
+	ref, ok := secretRefs[s]
+	return ref, ok
+}
+
+// Redact replaces every resolved secret found in s, e.g. a log message or an error, with Redacted
+func Redact(s string) string {
+	secretsMtx.RLock()
+	defer secretsMtx.RUnlock()
+	for secret := range secretRefs {
+		s = strings.Replace(s, secret, Redacted, -1)
+	}
+	return s
+}
+
+// RedactingWriter returns a writer redacting resolved secrets from everything written to w, for use as a log output
+func RedactingWriter(w io.Writer) io.Writer {
+	return redactingWriter{w}
+}
+
+// RedactLogs redacts resolved secrets from every log entry, whatever its destination, e.g. the Windows event log. It
+// is safe to call more than once.
+func RedactLogs() {
+	redactLogsOnce.Do(func() { log.AddHook(redactingHook{}) })
+}
+
+var redactLogsOnce sync.Once
+
+// redactingHook redacts resolved secrets from the message and fields of log entries before they are formatted
+type redactingHook struct{}
+
+func (redactingHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (redactingHook) Fire(e *logrus.Entry) error {
+	e.Message = Redact(e.Message)
+	for k, v := range e.Data {
+		if s, ok := v.(string); ok {
+			e.Data[k] = Redact(s)
+		}
+	}
+	return nil
+}
+
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
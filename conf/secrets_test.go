@@ -0,0 +1,63 @@
+package conf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/djonnala/wmi_exporter/log"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	f, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("s3cr3t-from-file\r\n")
+	f.Close()
+	os.Setenv("WMI_EXPORTER_TEST_SECRET", "s3cr3t-from-env")
+	defer os.Unsetenv("WMI_EXPORTER_TEST_SECRET")
+
+	c := ConfigurationParameters{
+		ServiceDiscovery: ConsulConf{Token: "file:" + f.Name()},
+		Service:          ServiceConf{ServiceName: "env:WMI_EXPORTER_TEST_SECRET"},
+	}
+	if err := ResolveSecrets(&c); err != nil {
+		t.Fatal(err)
+	}
+	// only credential fields hold references
+	if c.ServiceDiscovery.Token != "s3cr3t-from-file" || c.Service.ServiceName != "env:WMI_EXPORTER_TEST_SECRET" {
+		t.Errorf("unexpected resolved configuration %+v", c)
+	}
+	c.ServiceDiscovery.Token = "env:WMI_EXPORTER_TEST_SECRET"
+	if err := ResolveSecrets(&c); err != nil || c.ServiceDiscovery.Token != "s3cr3t-from-env" {
+		t.Errorf("expected the token to be read from the environment, got %q, %v", c.ServiceDiscovery.Token, err)
+	}
+
+	if s := Redact("token s3cr3t-from-env rejected"); s != "token "+Redacted+" rejected" {
+		t.Errorf("expected the secret to be redacted, got %q", s)
+	}
+	var dump bytes.Buffer
+	DumpConfig(&dump, c)
+	if strings.Contains(dump.String(), "s3cr3t") || !strings.Contains(dump.String(), `"env:WMI_EXPORTER_TEST_SECRET"`) {
+		t.Errorf("expected the dump to show references only, got\n%s", dump.String())
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	RedactLogs()
+	RedactLogs()
+	log.Errorln("cannot connect with token", c.ServiceDiscovery.Token)
+	log.SetOutput(os.Stderr)
+	if strings.Contains(logged.String(), "s3cr3t") || strings.Count(logged.String(), Redacted) != 1 {
+		t.Errorf("expected the secret to be redacted from logs, got %q", logged.String())
+	}
+
+	c.ServiceDiscovery.Token = "env:WMI_EXPORTER_TEST_MISSING"
+	if err := ResolveSecrets(&c); err == nil || !strings.Contains(err.Error(), "ServiceDiscovery.Token") {
+		t.Errorf("expected an unset variable to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/djonnala/wmi_exporter/collector"
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+// runConfig implements the config subcommand. config schema prints the JSON Schema of the configuration format, with a
+// section for every collector, including those not built for this platform.
+func runConfig(args []string) int {
+	defer trace()()
+	if len(args) == 0 || args[0] != "schema" {
+		fmt.Fprintln(os.Stderr, "usage: wmi_exporter config schema [-o file]")
+		return 2
+	}
+	fs := flag.NewFlagSet("config schema", flag.ExitOnError)
+	output := fs.String("o", "", "File to write the schema to, standard output when empty.")
+	fs.Parse(args[1:])
+
+	names := append([]string{}, collector.Known...)
+
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := conf.WriteSchema(w, names); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -4,22 +4,24 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	stdlog "log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/djonnala/go-tracey"
 
-	"golang.org/x/sys/windows/svc"
-
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/version"
 	"github.com/djonnala/wmi_exporter/collector"
 	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/djonnala/wmi_exporter/log"
 	"github.com/djonnala/wmi_exporter/utils"
 )
 
@@ -27,18 +29,58 @@ var trace = tracey.New(&conf.TraceConfig)
 
 // WmiCollector implements the prometheus.Collector interface.
 type WmiCollector struct {
+	collectors *collectorSet
+	// relabeler rewrites or drops collected metrics before exposition, nil when no rules are configured
+	relabeler *collector.Relabeler
+	// externalLabels are added to every collected metric, nil when none are configured
+	externalLabels *collector.ExternalLabels
+}
+
+// collectorSet holds the running collectors, replaced as a whole when the configuration changes
+type collectorSet struct {
+	sync.RWMutex
 	collectors map[string]collector.Collector
 }
 
+func (s *collectorSet) get() map[string]collector.Collector {
+	s.RLock()
+	defer s.RUnlock()
+	return s.collectors
+}
+
+// swap replaces the collectors and returns the previous ones
+func (s *collectorSet) swap(collectors map[string]collector.Collector) map[string]collector.Collector {
+	s.Lock()
+	defer s.Unlock()
+	old := s.collectors
+	s.collectors = collectors
+	return old
+}
+
 var (
+	scrapeDurationsDesc = collector.MetricDesc{
+		FQName:     prometheus.BuildFQName(collector.Namespace, "exporter", "scrape_duration_seconds"),
+		Help:       "wmi_exporter: Duration of a scrape job.",
+		LabelNames: []string{"collector", "result"},
+	}
 	scrapeDurations = prometheus.NewSummaryVec(
 		prometheus.SummaryOpts{
-			Namespace: collector.Namespace,
-			Subsystem: "exporter",
-			Name:      "scrape_duration_seconds",
-			Help:      "wmi_exporter: Duration of a scrape job.",
+			Name: scrapeDurationsDesc.FQName,
+			Help: scrapeDurationsDesc.Help,
+		},
+		scrapeDurationsDesc.LabelNames,
+	)
+	profileActiveDesc = collector.MetricDesc{
+		FQName:     prometheus.BuildFQName(collector.Namespace, "exporter", "collector_profile_active"),
+		Help:       "wmi_exporter: Whether the When condition of a configured collector holds on this host.",
+		LabelNames: []string{"collector"},
+	}
+	profileActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: profileActiveDesc.FQName,
+			Help: profileActiveDesc.Help,
 		},
-		[]string{"collector", "result"},
+		profileActiveDesc.LabelNames,
 	)
 )
 
@@ -47,6 +89,7 @@ var (
 func (coll WmiCollector) Describe(ch chan<- *prometheus.Desc) {
 	defer trace()()
 	scrapeDurations.Describe(ch)
+	profileActive.Describe(ch)
 }
 
 // Collect sends the collected metrics from each of the collectors to
@@ -54,16 +97,52 @@ func (coll WmiCollector) Describe(ch chan<- *prometheus.Desc) {
 // and thus its run is protected by a single mutex.
 func (coll WmiCollector) Collect(ch chan<- prometheus.Metric) {
 	defer trace()()
+	if coll.relabeler != nil || coll.externalLabels != nil {
+		rewriteCh := make(chan prometheus.Metric)
+		done := make(chan struct{})
+		go func(out chan<- prometheus.Metric) {
+			coll.rewrite(rewriteCh, out)
+			close(done)
+		}(ch)
+		defer func() {
+			close(rewriteCh)
+			<-done
+		}()
+		ch = rewriteCh
+	}
+	collectors := coll.collectors.get()
 	wg := sync.WaitGroup{}
-	wg.Add(len(coll.collectors))
-	for name, c := range coll.collectors {
+	wg.Add(len(collectors))
+	for name, c := range collectors {
 		go func(name string, c collector.Collector) {
 			execute(name, c, ch)
 			wg.Done()
 		}(name, c)
 	}
 	wg.Wait()
-	scrapeDurations.Collect(ch)
+	// described, so that they are rewritten like the metrics of the collectors
+	collector.CollectDescribed(scrapeDurations, scrapeDurationsDesc, ch)
+	collector.CollectDescribed(profileActive, profileActiveDesc, ch)
+}
+
+// rewrite relabels the metrics received on in and adds the external labels to the ones kept, until in is closed
+func (coll WmiCollector) rewrite(in <-chan prometheus.Metric, out chan<- prometheus.Metric) {
+	defer trace()()
+	for m := range in {
+		if m = coll.relabeler.Relabel(m); m != nil {
+			out <- coll.externalLabels.Apply(m)
+		}
+	}
+}
+
+// checkExternalLabels fails if a metric of the collectors already carries an external label once relabeled. It works
+// on the collector descriptors, so it is cheap enough to run on every reload.
+func (coll WmiCollector) checkExternalLabels(collectors map[string]collector.Collector) error {
+	defer trace()()
+	if collisions := coll.externalLabels.Collisions(collectors, coll.relabeler); len(collisions) > 0 {
+		return fmt.Errorf("external labels collide with collector labels:\n  %s", strings.Join(collisions, "\n  "))
+	}
+	return nil
 }
 
 func execute(name string, c collector.Collector, ch chan<- prometheus.Metric) {
@@ -91,10 +170,12 @@ func loadCollectors(coll map[string]conf.CollectorSpec) (map[string]collector.Co
 	for k := range coll {
 		fn, ok := collector.Factories[k]
 		if !ok {
+			stopCollectors(collectors)
 			return nil, fmt.Errorf("collector '%s' not available", k)
 		}
 		c, err := fn()
 		if err != nil {
+			stopCollectors(collectors)
 			return nil, err
 		}
 		collectors[k] = c
@@ -103,6 +184,87 @@ func loadCollectors(coll map[string]conf.CollectorSpec) (map[string]collector.Co
 	return collectors, nil
 }
 
+// activeCollectors returns the collectors whose When condition holds on the host, reporting every conditional one
+func activeCollectors(coll map[string]conf.CollectorSpec, host collector.HostAttributes) (map[string]conf.CollectorSpec, error) {
+	defer trace()()
+	profileActive.Reset()
+	active := map[string]conf.CollectorSpec{}
+	for k, spec := range coll {
+		if !spec.When.IsSet() {
+			active[k] = spec
+			continue
+		}
+		ok, reason, err := host.Matches(spec.When)
+		if err != nil {
+			return nil, fmt.Errorf("collector '%s': %s", k, err)
+		}
+		if ok {
+			log.Infof("Collector profile %s is active", k)
+			active[k] = spec
+			profileActive.WithLabelValues(k).Set(1)
+		} else {
+			log.Infof("Collector profile %s is inactive: %s", k, reason)
+			profileActive.WithLabelValues(k).Set(0)
+		}
+	}
+	return active, nil
+}
+
+// runConfigCheck validates the configuration file and reports every problem found, returning the exit code
+func runConfigCheck(configFile string, configDir string) int {
+	defer trace()()
+	if configFile == "" {
+		fmt.Fprintln(os.Stderr, "-config.check requires -config.file")
+		return 2
+	}
+	// collectors of other platforms are accepted, so that a configuration can be checked on a workstation or in CI
+	warned := map[string]bool{}
+	available := func(name string) bool {
+		if _, ok := collector.Factories[name]; ok {
+			return true
+		}
+		for _, k := range collector.Known {
+			if k == name {
+				if !warned[name] {
+					fmt.Fprintf(os.Stderr, "warning: collector '%s' is not part of this build for %s\n", name, runtime.GOOS)
+					warned[name] = true
+				}
+				return true
+			}
+		}
+		return false
+	}
+	include := ""
+	if configDir != "" {
+		dir, err := filepath.Abs(configDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		include = filepath.Join(dir, "*")
+	}
+	problems := conf.CheckConfig(configFile, include, available, collector.ValidateExpression, collector.NumericSource)
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s) found in %s\n", len(problems), configFile)
+		return 1
+	}
+	fmt.Printf("%s: configuration OK\n", configFile)
+	return 0
+}
+
+// stopCollectors ends the background work of any collectors that run some
+func stopCollectors(collectors map[string]collector.Collector) {
+	defer trace()()
+	for _, c := range collectors {
+		if s, ok := c.(collector.Stopper); ok {
+			s.Stop()
+		}
+	}
+}
+
 func init() {
 	defer trace()()
 	prometheus.MustRegister(version.NewCollector("wmi_exporter"))
@@ -114,10 +276,24 @@ func main() {
 		showVersion       = flag.Bool("version", false, "Print version information.")
 		printCollectors   = flag.Bool("collectors.print", false, "If true, print available collectors and exit.")
 		configFile        = flag.String("config.file", "", "complete path to configuration file")
+		configFormat      = flag.String("config.format", "", "Format of the configuration file: toml, yaml or json. Detected from the file extension when empty; fragments are always detected from theirs.")
+		configDir         = flag.String("config.dir", "", "Directory of configuration fragments (*.toml, *.yaml, *.yml, *.json) merged into the configuration file.")
+		checkConfig       = flag.Bool("config.check", false, "If true, validate the configuration file, report any problems and exit.")
 		listenAddress     = flag.String("telemetry.addr", conf.DefaultPlaceholder, "host:port for WMI exporter.")
 		metricsPath       = flag.String("telemetry.path", conf.DefaultPlaceholder, "URL path for surfacing collected metrics.")
-		enabledCollectors = flag.String("collectors.enabled", conf.DefaultPlaceholder, "Comma-separated list of collectors to use. Use '[default]' as a placeholder for all the collectors enabled by default")
+		enabledCollectors = flag.String("collectors.enabled", conf.DefaultPlaceholder, "Comma-separated list of collectors to use. Use '[defaults]' as a placeholder for all the collectors enabled by default")
+		dumpConfig        = flag.Bool("config.dump", false, "If true, print the effective configuration, annotated with where each value came from, and exit.")
+		configSet         overrideList
 	)
+	flag.Var(&configSet, "config.set", "Override a configuration value as Key=Value, e.g. Service.ListenPort=9103. May be repeated.")
+	// keep resolved secrets out of every log line, whether or not the message went through conf.Redact
+	conf.RedactLogs()
+	if len(os.Args) > 1 && os.Args[1] == "expr" {
+		os.Exit(runExpr(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfig(os.Args[2:]))
+	}
 	flag.Parse()
 
 	if *showVersion {
@@ -138,13 +314,31 @@ func main() {
 		return
 	}
 
+	conf.Format = *configFormat
+
+	if *checkConfig {
+		os.Exit(runConfigCheck(*configFile, *configDir))
+	}
+
 	//get all configurations loaded
-	conf.InitializeFromConfig(*configFile, *listenAddress, *metricsPath, *enabledCollectors)
+	overrides, err := flagOverrides(*listenAddress, *metricsPath, *enabledCollectors, *configDir, configSet)
+	if err != nil {
+		log.Fatal(err)
+	}
+	conf.InitializeFromConfig(*configFile, overrides...)
+	// some dependencies log through the standard logger, keep resolved secrets out of it too
+	stdlog.SetOutput(conf.RedactingWriter(os.Stderr))
+
+	if *dumpConfig {
+		conf.DumpConfig(os.Stdout, conf.UCMConfig)
+		return
+	}
 
-	//fetch AWS metadata and initialize it for registration
-	utils.FetchAWSMetadata()
+	//fetch the instance metadata and initialize it for registration
+	utils.FetchMetadata()
+	utils.FetchLabelTags()
 
-	//schedule fetching AWS tags as labels
+	//schedule fetching instance tags as labels
 	quitCh := make(chan bool)
 	if conf.UCMConfig.AwsTagsToLabels.Enabled {
 		ticker := time.NewTicker(time.Duration(conf.UCMConfig.AwsTagsToLabels.RefreshPeriod) * time.Second)
@@ -152,7 +346,7 @@ func main() {
 			for {
 				select {
 				case <-ticker.C:
-					utils.FetchAWSLabelTags()
+					utils.FetchLabelTags()
 				case <-quitCh:
 					ticker.Stop()
 					return
@@ -161,14 +355,14 @@ func main() {
 		}()
 	}
 
-	isInteractive, err := svc.IsAnInteractiveSession()
+	isInteractive, err := isInteractiveSession()
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	stopCh := make(chan bool)
 	if !isInteractive {
-		go svc.Run(conf.UCMConfig.Service.ServiceName, &wmiExporterService{stopCh: stopCh})
+		go runService(conf.UCMConfig.Service.ServiceName, stopCh)
 	}
 
 	// adding handler for SIGINT
@@ -181,16 +375,50 @@ func main() {
 		stopCh <- true
 	}()
 
-	collectors, err := loadCollectors(conf.UCMConfig.Collectors.EnabledCollectors)
+	// the local configuration, which every version of the remote configuration is merged over
+	local := conf.UCMConfig
+	var watcher *utils.ConfigWatcher
+	if conf.UCMConfig.RemoteConfig.Enabled {
+		watcher, err = loadRemoteConfig(local)
+		if err != nil {
+			log.Errorf("Couldn't load remote configuration, using the local one: %s", conf.Redact(err.Error()))
+		}
+	}
+
+	enabled, err := activeCollectors(conf.UCMConfig.Collectors.EnabledCollectors, collector.LocalHost(utils.HostTags()))
+	if err != nil {
+		log.Fatalf("Couldn't evaluate collector conditions: %s", err)
+	}
+	collectors, err := loadCollectors(enabled)
 	if err != nil {
 		log.Fatalf("Couldn't load collectors: %s", err)
 	}
 
 	//	log.Infof("Enabled collectors: %v", strings.Join(keys(collectors), ", "))
 
-	nodeCollector := WmiCollector{collectors: collectors}
+	relabeler, err := collector.NewRelabeler(conf.UCMConfig.MetricRelabelConfigs)
+	if err != nil {
+		log.Fatalf("Couldn't load metric relabel configs: %s", err)
+	}
+
+	externalLabels, err := conf.LoadExternalLabels(conf.UCMConfig)
+	if err != nil {
+		log.Fatalf("Couldn't load external labels: %s", err)
+	}
+	nodeCollector := WmiCollector{collectors: &collectorSet{collectors: collectors}, relabeler: relabeler, externalLabels: collector.NewExternalLabels(externalLabels)}
+	if err := nodeCollector.checkExternalLabels(nodeCollector.collectors.get()); err != nil {
+		log.Fatal(err)
+	}
 	prometheus.MustRegister(nodeCollector)
 
+	if watcher != nil {
+		watcher.Start(func(data []byte) {
+			if err := nodeCollector.reload(local, data, watcher.Key()); err != nil {
+				log.Errorf("Couldn't apply remote configuration, keeping the current collectors: %s", conf.Redact(err.Error()))
+			}
+		})
+	}
+
 	http.Handle(conf.UCMConfig.Service.MetricPath, prometheus.Handler())
 	http.HandleFunc("/health", healthCheck)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -215,7 +443,11 @@ func main() {
 	for {
 		if <-stopCh {
 			log.Info("Shutting down WMI exporter")
-			quitCh <- true
+			if watcher != nil {
+				watcher.Stop()
+			}
+			stopCollectors(nodeCollector.collectors.get())
+			// close rather than send, the tag refresh only runs with AwsTagsToLabels enabled
 			close(quitCh)
 			break
 		}
@@ -236,31 +468,3 @@ func keys(m map[string]collector.Collector) []string {
 	}
 	return ret
 }
-
-type wmiExporterService struct {
-	stopCh chan<- bool
-}
-
-func (s *wmiExporterService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
-	defer trace()()
-	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
-	changes <- svc.Status{State: svc.StartPending}
-	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
-loop:
-	for {
-		select {
-		case c := <-r:
-			switch c.Cmd {
-			case svc.Interrogate:
-				changes <- c.CurrentStatus
-			case svc.Stop, svc.Shutdown:
-				s.stopCh <- true
-				break loop
-			default:
-				log.Errorf("unexpected control request #%d", c)
-			}
-		}
-	}
-	changes <- svc.Status{State: svc.StopPending}
-	return
-}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/djonnala/wmi_exporter/collector"
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+// runExpr implements the expr subcommand. It evaluates a ComputeLogic expression against recorded or live source
+// values of a templated collector and prints every variable it resolved along the way.
+func runExpr(args []string) int {
+	defer trace()()
+	fs := flag.NewFlagSet("expr", flag.ExitOnError)
+	var (
+		configFile    = fs.String("config.file", "", "complete path to configuration file")
+		configFormat  = fs.String("config.format", "", "Format of the configuration file: toml, yaml or json. Detected from the file extension when empty.")
+		collectorName = fs.String("collector", "", "Templated collector whose source values are used.")
+		metric        = fs.String("metric", "", "ExportName of a configured metric whose ComputeLogic is evaluated.")
+		expression    = fs.String("e", "", "Expression to evaluate, instead of the ComputeLogic of -metric.")
+		snapshot      = fs.String("snapshot", "", "JSON file with recorded source values. Live values are collected when empty.")
+	)
+	fs.Parse(args)
+
+	conf.Format = *configFormat
+	if *configFile != "" {
+		conf.InitializeFromConfig(*configFile)
+	}
+
+	if *expression == "" && *metric != "" {
+		for _, m := range conf.UCMConfig.Collectors.EnabledCollectors[*collectorName].ExportedMetrics {
+			if m.ExportName == *metric {
+				*expression = collector.MetricExpression(m)
+			}
+		}
+		if *expression == "" {
+			fmt.Fprintf(os.Stderr, "metric '%s' is not configured for collector '%s'\n", *metric, *collectorName)
+			return 2
+		}
+	}
+	if *expression == "" {
+		fmt.Fprintln(os.Stderr, "either -e or -metric is required")
+		fs.Usage()
+		return 2
+	}
+
+	var t collector.CollectableTemplate
+	var err error
+	if *snapshot != "" {
+		f, ferr := os.Open(*snapshot)
+		if ferr != nil {
+			fmt.Fprintln(os.Stderr, ferr)
+			return 1
+		}
+		t, err = collector.NewSnapshotTemplate(f)
+		f.Close()
+	} else {
+		t, err = collector.LiveTemplate(*collectorName)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cannot load source values:", err)
+		return 1
+	}
+
+	res, err := collector.EvaluateExpression(*expression, t)
+	fmt.Printf("expression: %s\n", *expression)
+	if res != nil {
+		for _, v := range res.Vars {
+			fmt.Printf("  %s\n    metric: %s\n    labels: %s\n    value:  %v\n", v.Name, v.Metric, formatLabels(v.Labels), v.Value)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	fmt.Printf("result: %v\n", res.Value)
+	return 0
+}
+
+func formatLabels(l map[string]string) string {
+	defer trace()()
+	pairs := make([]string, 0, len(l))
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+// overrideList collects repeated -config.set Key=Value flags
+type overrideList []conf.Override
+
+func (o *overrideList) String() string {
+	return fmt.Sprint(*o)
+}
+
+func (o *overrideList) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("expected Key=Value, got %q", s)
+	}
+	*o = append(*o, conf.Override{Flag: "-config.set", Key: kv[0], Value: kv[1]})
+	return nil
+}
+
+// flagOverrides translates the dedicated command line flags into configuration overrides. Flags left at
+// conf.DefaultPlaceholder were not given and do not override anything.
+func flagOverrides(listenAddress, metricsPath, enabledCollectors, configDir string, set overrideList) ([]conf.Override, error) {
+	defer trace()()
+	var o []conf.Override
+	if listenAddress != conf.DefaultPlaceholder {
+		host, port, err := net.SplitHostPort(listenAddress)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse -telemetry.addr %s: %s", listenAddress, err)
+		}
+		o = append(o,
+			conf.Override{Flag: "-telemetry.addr", Key: "Service.ListenIP", Value: host},
+			conf.Override{Flag: "-telemetry.addr", Key: "Service.ListenPort", Value: port},
+		)
+	}
+	if metricsPath != conf.DefaultPlaceholder {
+		o = append(o, conf.Override{Flag: "-telemetry.path", Key: "Service.MetricPath", Value: metricsPath})
+	}
+	if enabledCollectors != conf.DefaultPlaceholder {
+		o = append(o, conf.Override{Flag: "-collectors.enabled", Key: "Collectors.EnabledCollectors", Value: enabledCollectors})
+	}
+	if configDir != "" {
+		dir, err := filepath.Abs(configDir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve -config.dir %s: %s", configDir, err)
+		}
+		o = append(o, conf.Override{Flag: "-config.dir", Key: "Include", Value: filepath.Join(dir, "*")})
+	}
+	return append(o, set...), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/djonnala/wmi_exporter/collector"
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/djonnala/wmi_exporter/log"
+	"github.com/djonnala/wmi_exporter/utils"
+)
+
+// loadRemoteConfig reads the remote configuration document and merges its collectors over the local configuration.
+// The watcher returned follows later changes; it is nil if the key cannot be expanded.
+func loadRemoteConfig(local conf.ConfigurationParameters) (*utils.ConfigWatcher, error) {
+	defer trace()()
+	watcher, err := utils.NewConfigWatcher(local.ServiceDiscovery, local.RemoteConfig)
+	if err != nil {
+		return nil, err
+	}
+	data, err := watcher.Load()
+	if err != nil {
+		return watcher, err
+	}
+	c, err := conf.MergeRemote(local, data, watcher.Key())
+	if err != nil {
+		return watcher, err
+	}
+	if err := conf.ValidateCollectors(c, collector.ValidateExpression, collector.NumericSource); err != nil {
+		return watcher, err
+	}
+	log.Infof("Loaded remote configuration from %s", watcher.Key())
+	conf.UCMConfig.Collectors = c.Collectors
+	return watcher, nil
+}
+
+// reload merges a new version of the remote configuration document over the local configuration and replaces the
+// running collectors with the ones it configures. The running collectors are kept if the document is invalid or any of
+// the new ones fails.
+func (coll WmiCollector) reload(local conf.ConfigurationParameters, data []byte, origin string) error {
+	defer trace()()
+	c, err := conf.MergeRemote(local, data, origin)
+	if err != nil {
+		return err
+	}
+	// remote documents get the checks -config.check runs on local files, and the collision check of startup below
+	if err := conf.ValidateCollectors(c, collector.ValidateExpression, collector.NumericSource); err != nil {
+		return err
+	}
+	enabled, err := activeCollectors(c.Collectors.EnabledCollectors, collector.LocalHost(utils.HostTags()))
+	if err != nil {
+		return err
+	}
+	// collectors read their settings from UCMConfig when they are built
+	previous := conf.UCMConfig.Collectors
+	conf.UCMConfig.Collectors = c.Collectors
+	collectors, err := loadCollectors(enabled)
+	if err != nil {
+		conf.UCMConfig.Collectors = previous
+		return err
+	}
+	if err := coll.checkExternalLabels(collectors); err != nil {
+		stopCollectors(collectors)
+		conf.UCMConfig.Collectors = previous
+		return err
+	}
+	stopCollectors(coll.collectors.swap(collectors))
+	log.Infof("Applied remote configuration from %s, running collectors: %v", origin, keys(collectors))
+	return nil
+}
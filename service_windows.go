@@ -0,0 +1,47 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"github.com/djonnala/wmi_exporter/log"
+	"golang.org/x/sys/windows/svc"
+)
+
+func isInteractiveSession() (bool, error) {
+	defer trace()()
+	return svc.IsAnInteractiveSession()
+}
+
+func runService(name string, stopCh chan<- bool) {
+	defer trace()()
+	svc.Run(name, &wmiExporterService{stopCh: stopCh})
+}
+
+type wmiExporterService struct {
+	stopCh chan<- bool
+}
+
+func (s *wmiExporterService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
+	defer trace()()
+	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
+	changes <- svc.Status{State: svc.StartPending}
+	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+loop:
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				s.stopCh <- true
+				break loop
+			default:
+				log.Errorf("unexpected control request #%d", c)
+			}
+		}
+	}
+	changes <- svc.Status{State: svc.StopPending}
+	return
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// generate-docs extracts the doc comments of the struct types declared in the given Go files, and of their fields,
+// into a Go map so that they are available at run time, e.g. for the descriptions of a JSON Schema.
+func main() {
+	var (
+		pkg    = flag.String("package", "conf", "Package of the generated file.")
+		name   = flag.String("var", "fieldDocs", "Name of the generated map.")
+		output = flag.String("o", "", "Output file, standard output when empty.")
+	)
+	flag.Parse()
+
+	docs := make(map[string]string)
+	fset := token.NewFileSet()
+	for _, file := range flag.Args() {
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			panic(err)
+		}
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				doc := ts.Doc
+				if doc == nil {
+					doc = gen.Doc
+				}
+				add(docs, ts.Name.Name, doc)
+				for _, field := range st.Fields.List {
+					for _, n := range field.Names {
+						add(docs, ts.Name.Name+"."+n.Name, field.Doc)
+					}
+				}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(docs))
+	for k := range docs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by tools/schema-docs from %s; DO NOT EDIT.\n\n", strings.Join(flag.Args(), ", "))
+	fmt.Fprintf(&buf, "package %s\n\n", *pkg)
+	fmt.Fprintf(&buf, "// %s holds the doc comments of the configuration types and their fields, keyed by Type or Type.Field\n", *name)
+	fmt.Fprintf(&buf, "var %s = map[string]string{\n", *name)
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "\t%s: %s,\n", strconv.Quote(k), strconv.Quote(docs[k]))
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	if *output == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+		panic(err)
+	}
+}
+
+// add records a doc comment as a single sentence-cased line, skipping placeholders such as "Type ..."
+func add(docs map[string]string, key string, doc *ast.CommentGroup) {
+	if doc == nil {
+		return
+	}
+	text := strings.Join(strings.Fields(doc.Text()), " ")
+	if text == "" || strings.HasSuffix(text, "...") {
+		return
+	}
+	r := []rune(text)
+	r[0] = unicode.ToUpper(r[0])
+	docs[key] = string(r)
+}
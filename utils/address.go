@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+// hostInterface is a network interface of the host with its addresses
+type hostInterface struct {
+	name  string
+	index int
+	addrs []net.IP
+}
+
+// byIndex sorts interfaces by index
+type byIndex []hostInterface
+
+func (b byIndex) Len() int           { return len(b) }
+func (b byIndex) Less(i, j int) bool { return b[i].index < b[j].index }
+func (b byIndex) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// hostInterfaces lists the interfaces of the host that are up
+func hostInterfaces() ([]hostInterface, error) {
+	defer trace()()
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var hosts []hostInterface
+	for _, i := range ifaces {
+		if i.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := i.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		h := hostInterface{name: i.Name, index: i.Index}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				h.addrs = append(h.addrs, ipnet.IP)
+			}
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
+// routeAddress returns the local address the host uses to reach endpoint. Dialing UDP sends nothing, it only picks
+// the route.
+func routeAddress(endpoint string) (net.IP, error) {
+	defer trace()()
+	conn, err := net.Dial("udp", endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// RegistrationAddress selects the address the exporter registers with, among the addresses of the host's interfaces
+func RegistrationAddress(c conf.ConsulConf) (string, error) {
+	defer trace()()
+	ifaces, err := hostInterfaces()
+	if err != nil {
+		return "", err
+	}
+	return selectAddress(c, ifaces, routeAddress)
+}
+
+// selectAddress selects the address to register with. With AddressFromRoute it is the local address of the route to
+// the Consul endpoint, which must not be a loopback or link-local one. Otherwise it is the first global unicast address
+// of the interfaces, in interface index order, restricted to AddressInterface and AddressCIDR when set. IPv4 addresses
+// come first, IPv6 addresses are used when there are no others.
+func selectAddress(c conf.ConsulConf, ifaces []hostInterface, route func(endpoint string) (net.IP, error)) (string, error) {
+	defer trace()()
+	var cidr *net.IPNet
+	if c.AddressCIDR != "" {
+		var err error
+		if _, cidr, err = net.ParseCIDR(c.AddressCIDR); err != nil {
+			return "", fmt.Errorf("invalid AddressCIDR: %s", err)
+		}
+	}
+
+	if c.AddressFromRoute {
+		ip, err := route(net.JoinHostPort(c.RemoteEndpoint, strconv.Itoa(c.RemotePort)))
+		if err != nil {
+			return "", fmt.Errorf("cannot find the route to %s: %s", c.RemoteEndpoint, err)
+		}
+		// a local Consul agent is reached over the loopback, an address nobody else can scrape
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			return "", fmt.Errorf("the address %s routing to %s cannot be reached from other hosts, "+
+				"set AddressCIDR or AddressInterface instead of AddressFromRoute", ip, c.RemoteEndpoint)
+		}
+		if cidr != nil && !cidr.Contains(ip) {
+			return "", fmt.Errorf("the address %s routing to %s is not in %s", ip, c.RemoteEndpoint, c.AddressCIDR)
+		}
+		return ip.String(), nil
+	}
+
+	sorted := append([]hostInterface{}, ifaces...)
+	sort.Stable(byIndex(sorted))
+	var v4, v6 []net.IP
+	var names []string
+	for _, i := range sorted {
+		names = append(names, i.name)
+		if c.AddressInterface != "" && !strings.EqualFold(i.name, c.AddressInterface) {
+			continue
+		}
+		for _, ip := range i.addrs {
+			if !ip.IsGlobalUnicast() || (cidr != nil && !cidr.Contains(ip)) {
+				continue
+			}
+			if ip.To4() != nil {
+				v4 = append(v4, ip)
+			} else {
+				v6 = append(v6, ip)
+			}
+		}
+	}
+	candidates := append(v4, v6...)
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no address found for registration (interface %q, CIDR %q) among interfaces %v",
+			c.AddressInterface, c.AddressCIDR, names)
+	}
+	return candidates[0].String(), nil
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+func TestSelectAddress(t *testing.T) {
+	ifaces := []hostInterface{
+		{name: "Ethernet1", index: 3, addrs: []net.IP{net.ParseIP("192.168.1.20"), net.ParseIP("2001:db8::20")}},
+		{name: "Loopback", index: 1, addrs: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}},
+		{name: "Ethernet0", index: 2, addrs: []net.IP{net.ParseIP("fe80::1"), net.ParseIP("2001:db8::10"), net.ParseIP("10.0.0.10")}},
+	}
+	route := func(endpoint string) (net.IP, error) {
+		if endpoint != "consul:8500" {
+			return nil, fmt.Errorf("unexpected endpoint %s", endpoint)
+		}
+		return net.ParseIP("192.168.1.20"), nil
+	}
+	cases := []struct {
+		c        conf.ConsulConf
+		expected string
+	}{
+		{conf.ConsulConf{}, "10.0.0.10"},
+		{conf.ConsulConf{AddressInterface: "ethernet1"}, "192.168.1.20"},
+		{conf.ConsulConf{AddressCIDR: "192.168.0.0/16"}, "192.168.1.20"},
+		{conf.ConsulConf{AddressCIDR: "2001:db8::/32"}, "2001:db8::10"},
+		{conf.ConsulConf{AddressInterface: "Ethernet1", AddressCIDR: "2001:db8::/32"}, "2001:db8::20"},
+		{conf.ConsulConf{AddressFromRoute: true, RemoteEndpoint: "consul", RemotePort: 8500}, "192.168.1.20"},
+	}
+	for _, c := range cases {
+		address, err := selectAddress(c.c, ifaces, route)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if address != c.expected {
+			t.Errorf("expected %s for %+v, got %s", c.expected, c.c, address)
+		}
+	}
+
+	if _, err := selectAddress(conf.ConsulConf{AddressInterface: "Loopback"}, ifaces, route); err == nil {
+		t.Errorf("expected an error when no address matches")
+	}
+	if _, err := selectAddress(conf.ConsulConf{}, nil, route); err == nil {
+		t.Errorf("expected an error on a host without addresses")
+	}
+	for _, local := range []string{"127.0.0.1", "::1", "169.254.10.1", "fe80::1"} {
+		ip := net.ParseIP(local)
+		c := conf.ConsulConf{AddressFromRoute: true, RemoteEndpoint: "localhost", RemotePort: 8500}
+		_, err := selectAddress(c, ifaces, func(string) (net.IP, error) { return ip, nil })
+		if err == nil || !strings.Contains(err.Error(), "set AddressCIDR or AddressInterface") {
+			t.Errorf("expected the route address %s to be rejected, got %v", local, err)
+		}
+	}
+}
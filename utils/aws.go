@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/djonnala/wmi_exporter/log"
+)
+
+// awsProvider reads the instance identity document and tags of EC2 instances
+type awsProvider struct {
+	imds *imdsClient
+}
+
+// Name is aws
+func (p *awsProvider) Name() string {
+	return "aws"
+}
+
+// Identity returns the attributes of the instance identity document, e.g. instanceId, region and availabilityZone
+func (p *awsProvider) Identity() (map[string]string, error) {
+	defer trace()()
+	resp, err := p.imds.get("dynamic/instance-identity/document")
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(resp, &doc); err != nil {
+		return nil, fmt.Errorf("cannot decode the EC2 instance identity document: %s", err)
+	}
+	id := make(map[string]string, len(doc))
+	for k, v := range doc {
+		switch vv := v.(type) {
+		case string:
+			id[k] = vv
+		case float64:
+			id[k] = strconv.FormatFloat(vv, 'f', -1, 64)
+		case []interface{}:
+			values := make([]string, 0, len(vv))
+			for _, e := range vv {
+				values = append(values, fmt.Sprint(e))
+			}
+			id[k] = strings.Join(values, ";")
+		case nil:
+		default:
+			log.Infoln("Found an unknown type", vv)
+		}
+	}
+	if id["instanceId"] == "" {
+		return nil, fmt.Errorf("no instanceId in the EC2 instance identity document")
+	}
+	return id, nil
+}
+
+// Tags gets the instance tags from the sources of AwsTagsToLabels.TagSource in turn, until one succeeds
+func (p *awsProvider) Tags(identity map[string]string) (map[string]string, error) {
+	defer trace()()
+	region := conf.UCMConfig.MetadataReporting.AWSRegion
+	if region == "" {
+		region = identity["region"]
+	}
+	var err error
+	for _, source := range tagSourceOrder(conf.UCMConfig.AwsTagsToLabels.TagSource) {
+		var tags map[string]string
+		if source == tagSourceIMDS {
+			tags, err = imdsTags(p.imds)
+		} else {
+			tags, err = apiTags(p.imds, identity["instanceId"], region)
+		}
+		if err == errTagsDisabled {
+			log.Debugf("Cannot get the instance tags from %s: %s", source, err)
+			continue
+		}
+		if err != nil {
+			metadataErrors.WithLabelValues("tags").Inc()
+			log.Warnf("AWS Label Tag Request Error. Failed to get the instance tags from %s: %s", source, conf.Redact(err.Error()))
+			continue
+		}
+		recordTagSource(source)
+		return tags, nil
+	}
+	return nil, fmt.Errorf("no tag source succeeded, last error: %s", err)
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+// azureAPIVersion is the version of the Azure instance metadata API requested, the first with tagsList
+const azureAPIVersion = "2019-06-04"
+
+// azureProvider reads the compute metadata of Azure virtual machines
+type azureProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// azureCompute is the part of the Azure instance metadata describing the virtual machine
+type azureCompute struct {
+	VMID              string `json:"vmId"`
+	Name              string `json:"name"`
+	Location          string `json:"location"`
+	Zone              string `json:"zone"`
+	ResourceGroupName string `json:"resourceGroupName"`
+	SubscriptionID    string `json:"subscriptionId"`
+	VMSize            string `json:"vmSize"`
+	TagsList          []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"tagsList"`
+}
+
+func newAzureProvider(c conf.MetaDataConf) *azureProvider {
+	return &azureProvider{baseURL: metadataURL(c, defaultMetadataURL), client: newMetadataHTTPClient(c)}
+}
+
+// Name is azure
+func (p *azureProvider) Name() string {
+	return "azure"
+}
+
+// compute gets the compute metadata of the virtual machine
+func (p *azureProvider) compute() (azureCompute, error) {
+	defer trace()()
+	var compute azureCompute
+	req, err := http.NewRequest("GET", p.baseURL+"/metadata/instance/compute?api-version="+azureAPIVersion, nil)
+	if err != nil {
+		return compute, err
+	}
+	// the metadata service only answers requests carrying this header
+	req.Header.Set("Metadata", "true")
+	body, err := fetch(p.client, req)
+	if err != nil {
+		return compute, err
+	}
+	if err := json.Unmarshal(body, &compute); err != nil {
+		return compute, fmt.Errorf("cannot decode the Azure compute metadata: %s", err)
+	}
+	return compute, nil
+}
+
+// Identity returns vmId, name, location, also as region, zone, resourceGroup, subscriptionId and vmSize, when set
+func (p *azureProvider) Identity() (map[string]string, error) {
+	defer trace()()
+	compute, err := p.compute()
+	if err != nil {
+		return nil, err
+	}
+	if compute.VMID == "" {
+		return nil, fmt.Errorf("no vmId in the Azure compute metadata")
+	}
+	id := make(map[string]string)
+	for k, v := range map[string]string{
+		"vmId":           compute.VMID,
+		"name":           compute.Name,
+		"location":       compute.Location,
+		"region":         compute.Location,
+		"zone":           compute.Zone,
+		"resourceGroup":  compute.ResourceGroupName,
+		"subscriptionId": compute.SubscriptionID,
+		"vmSize":         compute.VMSize,
+	} {
+		if v != "" {
+			id[k] = v
+		}
+	}
+	return id, nil
+}
+
+// Tags returns the tags of the virtual machine, part of the compute metadata
+func (p *azureProvider) Tags(identity map[string]string) (map[string]string, error) {
+	defer trace()()
+	compute, err := p.compute()
+	if err != nil {
+		metadataErrors.WithLabelValues("tags").Inc()
+		return nil, err
+	}
+	tags := make(map[string]string, len(compute.TagsList))
+	for _, t := range compute.TagsList {
+		tags[t.Name] = t.Value
+	}
+	recordTagSource(tagSourceIMDS)
+	return tags, nil
+}
@@ -0,0 +1,312 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/djonnala/wmi_exporter/log"
+	consul "github.com/hashicorp/consul/api"
+)
+
+const (
+	// defaultCheckInterval and defaultCheckTimeout apply to http checks, in seconds
+	defaultCheckInterval = 10
+	defaultCheckTimeout  = 5
+	// defaultCheckTTL applies to ttl checks, in seconds
+	defaultCheckTTL = 30
+)
+
+var (
+	ttlMtx sync.Mutex
+	// ttlQuit stops the goroutine reporting in to a ttl check, nil when none runs
+	ttlQuit chan bool
+)
+
+// consulClient returns a client for the Consul endpoint of c, with its ACL token, TLS settings and Enterprise
+// namespace and partition. Settings left empty fall back to the CONSUL_* environment variables.
+func consulClient(c conf.ConsulConf) (*consul.Client, error) {
+	defer trace()()
+	cconfig := consul.DefaultNonPooledConfig()
+	cconfig.Address = c.GetAddress()
+	if c.Token != "" {
+		cconfig.Token = c.Token
+	}
+	if c.TokenFile != "" {
+		cconfig.TokenFile = c.TokenFile
+	}
+	if c.TLSServerName != "" {
+		cconfig.TLSConfig.Address = c.TLSServerName
+	}
+	if c.CAFile != "" {
+		cconfig.TLSConfig.CAFile = c.CAFile
+	}
+	if c.CertFile != "" {
+		cconfig.TLSConfig.CertFile = c.CertFile
+		cconfig.TLSConfig.KeyFile = c.KeyFile
+	}
+	if c.InsecureSkipVerify {
+		cconfig.TLSConfig.InsecureSkipVerify = true
+	}
+	if c.Namespace != "" || c.Partition != "" {
+		httpClient, err := consul.NewHttpClient(cconfig.Transport, cconfig.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		httpClient.Transport = scopedTransport{next: httpClient.Transport, namespace: c.Namespace, partition: c.Partition}
+		cconfig.HttpClient = httpClient
+	}
+	return consul.NewClient(cconfig)
+}
+
+// scopedTransport adds the Consul Enterprise namespace and partition to every request, as the ns and partition query
+// parameters understood by all Consul versions
+type scopedTransport struct {
+	next      http.RoundTripper
+	namespace string
+	partition string
+}
+
+func (t scopedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request it was given
+	scoped := *r
+	u := *r.URL
+	q := u.Query()
+	if t.namespace != "" {
+		q.Set("ns", t.namespace)
+	}
+	if t.partition != "" {
+		q.Set("partition", t.partition)
+	}
+	u.RawQuery = q.Encode()
+	scoped.URL = &u
+	return t.next.RoundTrip(&scoped)
+}
+
+// checkID names the health check of the registered service
+func checkID(c conf.ConsulConf) string {
+	return "service:" + c.ServiceID
+}
+
+// seconds formats a duration in seconds as Consul expects it, or the default when unset
+func seconds(s int, def int) string {
+	if s <= 0 {
+		s = def
+	}
+	return strconv.Itoa(s) + "s"
+}
+
+// agentRegistration builds the registration of the exporter with a Consul agent, with an http check polling /health
+// at address and port over CheckScheme or a ttl check the exporter reports in to
+func agentRegistration(c conf.ConsulConf, address string, port int, md ServiceMetadata) *consul.AgentServiceRegistration {
+	defer trace()()
+	check := &consul.AgentServiceCheck{
+		CheckID: checkID(c),
+		Name:    c.ServiceID + " health check",
+	}
+	if c.CheckType == "ttl" {
+		check.TTL = seconds(c.CheckTTL, defaultCheckTTL)
+		check.Status = consul.HealthPassing
+	} else {
+		scheme := "http"
+		if c.CheckScheme != "" {
+			scheme = c.CheckScheme
+		}
+		check.HTTP = scheme + "://" + net.JoinHostPort(address, strconv.Itoa(port)) + "/health"
+		check.Interval = seconds(c.CheckInterval, defaultCheckInterval)
+		check.Timeout = seconds(c.CheckTimeout, defaultCheckTimeout)
+	}
+	if c.DeregisterCriticalServiceAfter > 0 {
+		check.DeregisterCriticalServiceAfter = seconds(c.DeregisterCriticalServiceAfter, 0)
+	}
+	return &consul.AgentServiceRegistration{
+		ID:      c.ServiceID,
+		Name:    c.RegisterServiceName,
+		Tags:    md.Tags,
+		Meta:    md.Meta,
+		Port:    port,
+		Address: address,
+		Check:   check,
+	}
+}
+
+// registerWithAgent registers the exporter through the Consul agent of c and, for a ttl check, starts reporting in
+// every half TTL until deregisterFromAgent
+func registerWithAgent(c conf.ConsulConf, address string, port int, md ServiceMetadata) error {
+	defer trace()()
+	client, err := consulClient(c)
+	if err != nil {
+		return err
+	}
+	agent := client.Agent()
+	if err := agent.ServiceRegister(agentRegistration(c, address, port, md)); err != nil {
+		return fmt.Errorf("consul agent registration failed: %s", err)
+	}
+	if c.CheckType == "ttl" {
+		ttl := time.Duration(c.CheckTTL) * time.Second
+		if c.CheckTTL <= 0 {
+			ttl = defaultCheckTTL * time.Second
+		}
+		startTTL(agent, checkID(c), ttl/2)
+	}
+	return nil
+}
+
+// startTTL reports the exporter as passing to the ttl check id every period, replacing any earlier reporter
+func startTTL(agent *consul.Agent, id string, period time.Duration) {
+	defer trace()()
+	stopTTL()
+	quit := make(chan bool)
+	ttlMtx.Lock()
+	ttlQuit = quit
+	ttlMtx.Unlock()
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := agent.UpdateTTL(id, "wmi_exporter is running", consul.HealthPassing); err != nil {
+					log.Error(conf.Redact(err.Error()))
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
+
+// stopTTL stops reporting in to a ttl check
+func stopTTL() {
+	ttlMtx.Lock()
+	defer ttlMtx.Unlock()
+	if ttlQuit != nil {
+		close(ttlQuit)
+		ttlQuit = nil
+	}
+}
+
+// deregisterFromAgent removes the exporter and its check from the Consul agent of c
+func deregisterFromAgent(c conf.ConsulConf) error {
+	defer trace()()
+	stopTTL()
+	client, err := consulClient(c)
+	if err != nil {
+		return err
+	}
+	if err := client.Agent().ServiceDeregister(c.ServiceID); err != nil {
+		return fmt.Errorf("consul agent deregistration failed: %s", err)
+	}
+	return nil
+}
+
+// registerWithCatalog writes a catalog entry for the exporter on node, with a check that is always passing
+func registerWithCatalog(c conf.ConsulConf, node string, address string, port int, md ServiceMetadata) error {
+	defer trace()()
+	reg := consul.CatalogRegistration{
+		Node:       node,
+		Address:    address,
+		Datacenter: c.Datacenter,
+		Service: &consul.AgentService{
+			ID:      c.ServiceID,
+			Service: c.RegisterServiceName,
+			Tags:    md.Tags,
+			Meta:    md.Meta,
+			Port:    port,
+			Address: address,
+		},
+		Check: &consul.AgentCheck{
+			Node:      node,
+			CheckID:   checkID(c),
+			Name:      c.ServiceID + " health check",
+			Status:    consul.HealthPassing,
+			ServiceID: c.ServiceID,
+		},
+	}
+	client, err := consulClient(c)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Catalog().Register(&reg, &consul.WriteOptions{}); err != nil {
+		return fmt.Errorf("consul catalog registration failed: %s", err)
+	}
+	return nil
+}
+
+// deregisterFromCatalog removes the catalog entry of the exporter on node
+func deregisterFromCatalog(c conf.ConsulConf, node string) error {
+	defer trace()()
+	dereg := consul.CatalogDeregistration{
+		Node:       node,
+		Datacenter: c.Datacenter,
+		ServiceID:  c.ServiceID,
+	}
+	client, err := consulClient(c)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Catalog().Deregister(&dereg, nil); err != nil {
+		return fmt.Errorf("consul catalog deregistration failed: %s", err)
+	}
+	return nil
+}
+
+// isRegistered tells whether Consul still knows the exporter, as a service of the agent or a catalog entry on node
+func isRegistered(c conf.ConsulConf, node string) (bool, error) {
+	defer trace()()
+	client, err := consulClient(c)
+	if err != nil {
+		return false, err
+	}
+	if c.Mode == "agent" {
+		services, err := client.Agent().Services()
+		if err != nil {
+			return false, err
+		}
+		_, ok := services[c.ServiceID]
+		return ok, nil
+	}
+	services, _, err := client.Catalog().Service(c.RegisterServiceName, "", &consul.QueryOptions{Datacenter: c.Datacenter})
+	if err != nil {
+		return false, err
+	}
+	for _, s := range services {
+		if s.ServiceID == c.ServiceID && s.Node == node {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// consulRegistrar registers the exporter with Consul, through the agent or straight into the catalog as c.Mode says
+type consulRegistrar struct {
+	conf    conf.ConsulConf
+	node    string
+	address string
+	port    int
+}
+
+func (r consulRegistrar) Register(md ServiceMetadata) error {
+	defer trace()()
+	if r.conf.Mode == "agent" {
+		return registerWithAgent(r.conf, r.address, r.port, md)
+	}
+	return registerWithCatalog(r.conf, r.node, r.address, r.port, md)
+}
+
+func (r consulRegistrar) Registered() (bool, error) {
+	defer trace()()
+	return isRegistered(r.conf, r.node)
+}
+
+func (r consulRegistrar) Deregister() error {
+	defer trace()()
+	if r.conf.Mode == "agent" {
+		return deregisterFromAgent(r.conf)
+	}
+	return deregisterFromCatalog(r.conf, r.node)
+}
@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	consul "github.com/hashicorp/consul/api"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// standInAgent records the requests a Consul agent receives
+type standInAgent struct {
+	sync.Mutex
+	// fail makes every request fail
+	fail          bool
+	registrations int
+	registered    *consul.AgentServiceRegistration
+	ttlUpdates    int
+	deregistered  string
+	// token and namespace are those of the last request
+	token     string
+	namespace string
+}
+
+func (a *standInAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	defer a.Unlock()
+	a.token = r.Header.Get("X-Consul-Token")
+	a.namespace = r.URL.Query().Get("ns")
+	switch {
+	case a.fail:
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+	case r.URL.Path == "/v1/agent/service/register":
+		a.registered = &consul.AgentServiceRegistration{}
+		json.NewDecoder(r.Body).Decode(a.registered)
+		a.registrations++
+	case r.URL.Path == "/v1/agent/services":
+		services := map[string]consul.AgentService{}
+		if a.registered != nil {
+			services[a.registered.ID] = consul.AgentService{ID: a.registered.ID, Service: a.registered.Name, Meta: a.registered.Meta}
+		}
+		json.NewEncoder(w).Encode(services)
+	case r.URL.Path == "/v1/agent/check/update/service:wmi-1":
+		a.ttlUpdates++
+	case r.URL.Path == "/v1/agent/service/deregister/wmi-1":
+		a.deregistered = "wmi-1"
+		a.registered = nil
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func standInConf(t *testing.T, url string) conf.ConsulConf {
+	host, port, err := net.SplitHostPort(strings.SplitN(url, "://", 2)[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	return conf.ConsulConf{Enabled: true, Mode: "agent", RemoteEndpoint: host, RemotePort: p, ServiceID: "wmi-1", RegisterServiceName: "wmi"}
+}
+
+func TestRegisterWithAgent(t *testing.T) {
+	agent := &standInAgent{}
+	srv := httptest.NewServer(agent)
+	defer srv.Close()
+
+	c := standInConf(t, srv.URL)
+	c.DeregisterCriticalServiceAfter = 600
+	if err := registerWithAgent(c, "10.0.0.5", 9182, ServiceMetadata{Meta: map[string]string{"role": "web"}}); err != nil {
+		t.Fatal(err)
+	}
+	check := agent.registered.Check
+	if check.HTTP != "http://10.0.0.5:9182/health" || check.Interval != "10s" || check.DeregisterCriticalServiceAfter != "600s" {
+		t.Errorf("unexpected http check %+v", check)
+	}
+	c.CheckScheme = "https"
+	if err := registerWithAgent(c, "10.0.0.5", 9182, ServiceMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if check := agent.registered.Check; check.HTTP != "https://10.0.0.5:9182/health" {
+		t.Errorf("expected the check to use the configured scheme, got %s", check.HTTP)
+	}
+
+	c.CheckType = "ttl"
+	c.CheckTTL = 1
+	if err := registerWithAgent(c, "10.0.0.5", 9182, ServiceMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if check := agent.registered.Check; check.TTL != "1s" || check.HTTP != "" {
+		t.Errorf("unexpected ttl check %+v", check)
+	}
+	time.Sleep(1200 * time.Millisecond)
+	if err := deregisterFromAgent(c); err != nil {
+		t.Fatal(err)
+	}
+	agent.Lock()
+	defer agent.Unlock()
+	if agent.ttlUpdates == 0 {
+		t.Errorf("expected the ttl check to be refreshed")
+	}
+	if agent.deregistered != "wmi-1" {
+		t.Errorf("expected the service to be deregistered")
+	}
+}
+
+func TestConsulClientTLS(t *testing.T) {
+	agent := &standInAgent{}
+	srv := httptest.NewTLSServer(agent)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.TLS.Certificates[0].Certificate[0]})
+	if err := ioutil.WriteFile(ca, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	token := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(token, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := standInConf(t, srv.URL)
+	c.Scheme, c.CAFile, c.TLSServerName, c.TokenFile, c.Namespace = "https", ca, "example.com", token, "team-a"
+	if err := registerWithAgent(c, "10.0.0.5", 9182, ServiceMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+	if agent.token != "s3cr3t" || agent.namespace != "team-a" {
+		t.Errorf("expected token and namespace to be sent, got %q and %q", agent.token, agent.namespace)
+	}
+
+	c.TLSServerName = "consul.invalid"
+	if err := registerWithAgent(c, "10.0.0.5", 9182, ServiceMetadata{}); err == nil {
+		t.Errorf("expected a server name mismatch to fail")
+	}
+}
+
+// waitFor polls cond until it holds or a few seconds passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestRegistrationManager(t *testing.T) {
+	agent := &standInAgent{fail: true}
+	srv := httptest.NewServer(agent)
+	defer srv.Close()
+
+	var mdMtx sync.Mutex
+	md := ServiceMetadata{Meta: map[string]string{"role": "web"}}
+	registrar := consulRegistrar{conf: standInConf(t, srv.URL), node: "node", address: "10.0.0.5", port: 9182}
+	m := newRegistrationManager(registrar, func() ServiceMetadata {
+		mdMtx.Lock()
+		defer mdMtx.Unlock()
+		return md
+	}, 0)
+	m.interval, m.minBackoff, m.maxBackoff = 20*time.Millisecond, 10*time.Millisecond, 20*time.Millisecond
+	errors := consulErrors.WithLabelValues("register")
+	m.start()
+
+	waitFor(t, "failed registrations", func() bool {
+		var d dto.Metric
+		errors.Write(&d)
+		return d.GetCounter().GetValue() >= 2
+	})
+	agent.Lock()
+	agent.fail = false
+	agent.Unlock()
+	registrations := func(n int) func() bool {
+		return func() bool {
+			agent.Lock()
+			defer agent.Unlock()
+			return agent.registrations >= n && agent.registered != nil
+		}
+	}
+	waitFor(t, "the registration", registrations(1))
+
+	mdMtx.Lock()
+	md = ServiceMetadata{Meta: map[string]string{"role": "api"}}
+	mdMtx.Unlock()
+	waitFor(t, "the registration with new metadata", func() bool {
+		agent.Lock()
+		defer agent.Unlock()
+		return agent.registered != nil && agent.registered.Meta["role"] == "api"
+	})
+
+	agent.Lock()
+	agent.registered = nil
+	n := agent.registrations
+	agent.Unlock()
+	waitFor(t, "the registration to be restored", registrations(n+1))
+
+	m.stop()
+	var d dto.Metric
+	consulRegistered.Write(&d)
+	if d.GetGauge().GetValue() != 0 || agent.deregistered != "wmi-1" {
+		t.Errorf("expected the exporter to be deregistered")
+	}
+}
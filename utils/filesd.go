@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// invalidLabelChars matches what Prometheus does not accept in label names
+var invalidLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// fileSDTarget is an entry of a Prometheus file_sd target file
+type fileSDTarget struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// fileSDRegistrar announces the exporter in a Prometheus file_sd target file, e.g. on a share Prometheus reads from
+type fileSDRegistrar struct {
+	path   string
+	target string
+}
+
+func newFileSDRegistrar(path string, address string, port int) fileSDRegistrar {
+	defer trace()()
+	return fileSDRegistrar{path: path, target: net.JoinHostPort(address, strconv.Itoa(port))}
+}
+
+// Register writes the target file, with the metadata as labels. The file is replaced atomically, so that Prometheus
+// never reads it half written.
+func (r fileSDRegistrar) Register(md ServiceMetadata) error {
+	defer trace()()
+	target := fileSDTarget{Targets: []string{r.target}}
+	if len(md.Meta) > 0 {
+		target.Labels = make(map[string]string, len(md.Meta))
+		for k, v := range md.Meta {
+			target.Labels[invalidLabelChars.ReplaceAllString(k, "_")] = v
+		}
+	}
+	data, err := json.MarshalIndent([]fileSDTarget{target}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(r.path, append(data, '\n'))
+}
+
+// writeFileAtomic replaces the file at path with data, through a temporary file in the same directory renamed over it
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Registered tells whether the target file still exists
+func (r fileSDRegistrar) Registered() (bool, error) {
+	defer trace()()
+	if _, err := os.Stat(r.path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Deregister removes the target file
+func (r fileSDRegistrar) Deregister() error {
+	defer trace()()
+	if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileSDRegistrar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wmi.json")
+	r := newFileSDRegistrar(path, "10.0.0.5", 9182)
+
+	if ok, err := r.Registered(); ok || err != nil {
+		t.Fatalf("expected no target file yet, got %v, %v", ok, err)
+	}
+	if err := r.Register(ServiceMetadata{Meta: map[string]string{"cost-center": "42"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var targets []fileSDTarget
+	if err := json.Unmarshal(data, &targets); err != nil {
+		t.Fatal(err)
+	}
+	expected := []fileSDTarget{{Targets: []string{"10.0.0.5:9182"}, Labels: map[string]string{"cost_center": "42"}}}
+	if !reflect.DeepEqual(targets, expected) {
+		t.Errorf("expected %v, got %v", expected, targets)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expected only the target file to be left, got %d files", len(files))
+	}
+
+	if err := r.Deregister(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := r.Registered(); ok {
+		t.Errorf("expected the target file to be removed")
+	}
+}
+
+func TestDeRegisterFileSD(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wmi.json")
+
+	registration = newRegistrationManager(newFileSDRegistrar(path, "10.0.0.5", 9182), func() ServiceMetadata {
+		return ServiceMetadata{}
+	}, 0)
+	registration.start()
+	waitFor(t, "the target file", func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	})
+	DeRegister()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the target file to be removed on shutdown, got %v", err)
+	}
+	if registration != nil {
+		t.Errorf("expected the registration to be cleared")
+	}
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+const (
+	// defaultGCEMetadataURL is the metadata server of Compute Engine
+	defaultGCEMetadataURL = "http://metadata.google.internal"
+	// defaultComputeURL is the Compute Engine API, labels are not part of the instance metadata
+	defaultComputeURL = "https://compute.googleapis.com"
+)
+
+// gceProvider reads the metadata of Compute Engine instances, and their labels through the Compute Engine API with
+// the credentials of the instance service account
+type gceProvider struct {
+	baseURL    string
+	computeURL string
+	client     *http.Client
+	apiClient  *http.Client
+}
+
+func newGCEProvider(c conf.MetaDataConf) *gceProvider {
+	return &gceProvider{
+		baseURL:    metadataURL(c, defaultGCEMetadataURL),
+		computeURL: defaultComputeURL,
+		client:     newMetadataHTTPClient(c),
+		apiClient:  &http.Client{Timeout: metadataTimeout(c)},
+	}
+}
+
+// Name is gce
+func (p *gceProvider) Name() string {
+	return "gce"
+}
+
+// get returns the metadata at path under /computeMetadata/v1/
+func (p *gceProvider) get(path string) ([]byte, error) {
+	defer trace()()
+	req, err := http.NewRequest("GET", p.baseURL+"/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the metadata server only answers requests carrying this header
+	req.Header.Set("Metadata-Flavor", "Google")
+	return fetch(p.client, req)
+}
+
+// lastSegment returns what follows the last / of a resource name, e.g. the zone of projects/123/zones/europe-west1-b
+func lastSegment(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// Identity returns instanceId, name, hostname, projectId, zone, region and machineType
+func (p *gceProvider) Identity() (map[string]string, error) {
+	defer trace()()
+	body, err := p.get("instance/?recursive=true")
+	if err != nil {
+		return nil, err
+	}
+	var instance struct {
+		// instance ids do not fit in a float64
+		ID          json.Number `json:"id"`
+		Name        string      `json:"name"`
+		Hostname    string      `json:"hostname"`
+		Zone        string      `json:"zone"`
+		MachineType string      `json:"machineType"`
+	}
+	if err := json.Unmarshal(body, &instance); err != nil {
+		return nil, fmt.Errorf("cannot decode the GCE instance metadata: %s", err)
+	}
+	if instance.ID == "" {
+		return nil, fmt.Errorf("no id in the GCE instance metadata")
+	}
+	project, err := p.get("project/project-id")
+	if err != nil {
+		return nil, err
+	}
+	zone := lastSegment(instance.Zone)
+	id := map[string]string{
+		"instanceId":  instance.ID.String(),
+		"name":        instance.Name,
+		"hostname":    instance.Hostname,
+		"projectId":   string(project),
+		"zone":        zone,
+		"machineType": lastSegment(instance.MachineType),
+	}
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		id["region"] = zone[:i]
+	}
+	return id, nil
+}
+
+// Tags returns the labels of the instance, read from the Compute Engine API with an access token of the instance
+// service account, which needs the compute.instances.get permission
+func (p *gceProvider) Tags(identity map[string]string) (map[string]string, error) {
+	defer trace()()
+	labels, err := p.labels(identity)
+	if err != nil {
+		metadataErrors.WithLabelValues("tags").Inc()
+		return nil, err
+	}
+	recordTagSource(tagSourceAPI)
+	return labels, nil
+}
+
+func (p *gceProvider) labels(identity map[string]string) (map[string]string, error) {
+	body, err := p.get("instance/service-accounts/default/token")
+	if err != nil {
+		return nil, fmt.Errorf("cannot get an access token: %s", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("cannot decode the access token: %s", err)
+	}
+
+	url := fmt.Sprintf("%s/compute/v1/projects/%s/zones/%s/instances/%s?fields=labels",
+		p.computeURL, identity["projectId"], identity["zone"], identity["name"])
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	body, err = fetch(p.apiClient, req)
+	if err != nil {
+		return nil, err
+	}
+	var instance struct {
+		Labels map[string]string `json:"labels"`
+	}
+	if err := json.Unmarshal(body, &instance); err != nil {
+		return nil, fmt.Errorf("cannot decode the instance labels: %s", err)
+	}
+	return instance.Labels, nil
+}
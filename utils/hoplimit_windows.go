@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import "syscall"
+
+// setHopLimit sets the IP TTL, or the IPv6 hop limit, of the packets sent on a socket
+func setHopLimit(fd uintptr, ipv6 bool, hops int) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, hops)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TTL, hops)
+}
@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// defaultMetadataURL is the instance metadata service of EC2, and of Azure
+	defaultMetadataURL = "http://169.254.169.254"
+	// defaultTokenTTL is the lifetime of IMDSv2 session tokens, in seconds, the longest IMDS allows
+	defaultTokenTTL = 21600
+	// defaultConnectTimeout and defaultTimeout bound metadata requests, in seconds
+	defaultConnectTimeout = 1
+	defaultTimeout        = 5
+)
+
+var (
+	metadataErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "wmi_exporter",
+			Subsystem: "metadata",
+			Name:      "errors_total",
+			Help:      "wmi_exporter: Failed instance metadata requests, by request.",
+		},
+		[]string{"request"},
+	)
+	metadataUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "wmi_exporter",
+			Subsystem: "metadata",
+			Name:      "up",
+			Help:      "wmi_exporter: Whether the last instance metadata fetch succeeded.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metadataErrors, metadataUp)
+}
+
+// imdsClient queries the EC2 instance metadata service, with IMDSv2 session tokens unless configured otherwise
+type imdsClient struct {
+	baseURL  string
+	version  string
+	tokenTTL int
+	client   *http.Client
+
+	mtx         sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func newIMDSClient(c conf.MetaDataConf) *imdsClient {
+	defer trace()()
+	m := &imdsClient{baseURL: metadataURL(c, defaultMetadataURL), version: c.IMDSVersion, tokenTTL: c.TokenTTL}
+	if m.version == "" {
+		m.version = "v2"
+	}
+	if m.tokenTTL <= 0 {
+		m.tokenTTL = defaultTokenTTL
+	}
+	m.client = newMetadataHTTPClient(c)
+	return m
+}
+
+// metadataURL returns the configured base URL of the metadata service, or def
+func metadataURL(c conf.MetaDataConf, def string) string {
+	if c.MetadataURL == "" {
+		return def
+	}
+	return strings.TrimRight(c.MetadataURL, "/")
+}
+
+// newMetadataHTTPClient returns a client for the metadata service with the configured timeouts and hop limit
+func newMetadataHTTPClient(c conf.MetaDataConf) *http.Client {
+	connectTimeout := time.Duration(c.ConnectTimeout) * time.Second
+	if c.ConnectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout * time.Second
+	}
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	if c.HopLimit > 0 {
+		hopLimit := c.HopLimit
+		dialer.Control = func(network, address string, rc syscall.RawConn) error {
+			var err error
+			if cerr := rc.Control(func(fd uintptr) { err = setHopLimit(fd, strings.HasSuffix(network, "6"), hopLimit) }); cerr != nil {
+				return cerr
+			}
+			return err
+		}
+	}
+	// the metadata service is local, never go through a proxy
+	return &http.Client{Timeout: metadataTimeout(c), Transport: &http.Transport{DialContext: dialer.DialContext}}
+}
+
+// metadataTimeout returns the configured bound of metadata requests
+func metadataTimeout(c conf.MetaDataConf) time.Duration {
+	if c.Timeout <= 0 {
+		return defaultTimeout * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// sessionToken returns an IMDSv2 session token, requesting a new one when the current one is about to expire
+func (m *imdsClient) sessionToken() (string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.token != "" && time.Now().Before(m.tokenExpiry) {
+		return m.token, nil
+	}
+	req, err := http.NewRequest("PUT", m.baseURL+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", strconv.Itoa(m.tokenTTL))
+	body, err := fetch(m.client, req)
+	if err != nil {
+		return "", fmt.Errorf("cannot get an IMDSv2 token: %s", err)
+	}
+	m.token = string(body)
+	// renew ahead of the expiry, so that a token never expires in flight
+	m.tokenExpiry = time.Now().Add(time.Duration(m.tokenTTL) * time.Second * 9 / 10)
+	return m.token, nil
+}
+
+// get returns the content at path under /latest/, e.g. dynamic/instance-identity/document
+func (m *imdsClient) get(path string) ([]byte, error) {
+	defer trace()()
+	req, err := http.NewRequest("GET", m.baseURL+"/latest/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if m.version != "v1" {
+		token, err := m.sessionToken()
+		switch {
+		case err == nil:
+			req.Header.Set("X-aws-ec2-metadata-token", token)
+		case m.version == "v2":
+			return nil, err
+		}
+	}
+	body, err := fetch(m.client, req)
+	if err, ok := err.(statusError); ok && err == http.StatusUnauthorized && m.version != "v1" {
+		// the token expired early or was revoked, get a new one and try again
+		m.mtx.Lock()
+		m.token = ""
+		m.mtx.Unlock()
+		token, terr := m.sessionToken()
+		if terr != nil {
+			return nil, terr
+		}
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+		return fetch(m.client, req)
+	}
+	return body, err
+}
+
+// statusError is an unexpected HTTP status of the metadata service
+type statusError int
+
+func (s statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", int(s), http.StatusText(int(s)))
+}
+
+// fetch sends req and returns the body of the response
+func fetch(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp.StatusCode)
+	}
+	return body, nil
+}
+
+// imdsRoleProvider provides the credentials of the instance role from the metadata service, so that AWS API calls
+// work on instances requiring IMDSv2
+type imdsRoleProvider struct {
+	imds       *imdsClient
+	expiration time.Time
+}
+
+// Retrieve fetches the credentials of the first role attached to the instance
+func (p *imdsRoleProvider) Retrieve() (credentials.Value, error) {
+	defer trace()()
+	roles, err := p.imds.get("meta-data/iam/security-credentials/")
+	if err != nil {
+		metadataErrors.WithLabelValues("credentials").Inc()
+		return credentials.Value{}, err
+	}
+	role, err := bufio.NewReader(bytes.NewReader(roles)).ReadString('\n')
+	if role = strings.TrimSpace(role); role == "" {
+		return credentials.Value{}, fmt.Errorf("no instance role found: %v", err)
+	}
+	body, err := p.imds.get("meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		metadataErrors.WithLabelValues("credentials").Inc()
+		return credentials.Value{}, err
+	}
+	var creds struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(body, &creds); err != nil {
+		return credentials.Value{}, fmt.Errorf("cannot decode the credentials of role %s: %s", role, err)
+	}
+	p.expiration = creds.Expiration
+	return credentials.Value{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.Token,
+		ProviderName:    "imdsRoleProvider",
+	}, nil
+}
+
+// IsExpired tells whether the credentials expire within five minutes
+func (p *imdsRoleProvider) IsExpired() bool {
+	return time.Now().Add(5 * time.Minute).After(p.expiration)
+}
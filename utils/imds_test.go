@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// standInIMDS serves an instance identity document, only to requests with an IMDSv2 token unless v1 is allowed
+type standInIMDS struct {
+	sync.Mutex
+	allowV1 bool
+	tokens  int
+	// tags are served from tags/instance, not found when nil as on instances without metadata tags
+	tags map[string]string
+}
+
+func (m *standInIMDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+	switch {
+	case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+		if r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			http.Error(w, "missing ttl", http.StatusBadRequest)
+			return
+		}
+		m.tokens++
+		w.Write([]byte("t0k3n"))
+	case r.Header.Get("X-aws-ec2-metadata-token") != "t0k3n" && !m.allowV1:
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	case r.URL.Path == "/latest/meta-data/tags/instance" && m.tags != nil:
+		for k := range m.tags {
+			fmt.Fprintln(w, k)
+		}
+	case strings.HasPrefix(r.URL.Path, "/latest/meta-data/tags/instance/") && m.tags != nil:
+		v, ok := m.tags[strings.TrimPrefix(r.URL.Path, "/latest/meta-data/tags/instance/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(v))
+	case r.URL.Path == "/latest/dynamic/instance-identity/document":
+		w.Write([]byte(`{"instanceId": "i-0123", "region": "eu-west-1", "version": 2017, "billingProducts": ["a", "b"], "kernelId": null}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestFetchMetadataAWS(t *testing.T) {
+	imds := &standInIMDS{}
+	srv := httptest.NewServer(imds)
+	defer srv.Close()
+
+	defer restoreMetadata()()
+	conf.UCMConfig.MetadataReporting = conf.MetaDataConf{MetadataURL: srv.URL, HopLimit: 2}
+	FetchMetadata()
+	tags := HostTags()
+	if tags["instanceId"] != "i-0123" || tags["version"] != "2017" || tags["billingProducts"] != "a;b" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	if provider == nil || provider.Name() != "aws" {
+		t.Errorf("expected aws to be detected, got %v", provider)
+	}
+	if imds.tokens != 1 {
+		t.Errorf("expected a single token request, got %d", imds.tokens)
+	}
+
+	// without tokens IMDSv1 fails against an instance requiring v2, and the failure is counted
+	errors := metadataErrors.WithLabelValues("identity")
+	var before, after dto.Metric
+	errors.Write(&before)
+	labels = nil
+	conf.UCMConfig.MetadataReporting.IMDSVersion = "v1"
+	FetchMetadata()
+	errors.Write(&after)
+	if len(HostTags()) != 0 || after.GetCounter().GetValue() != before.GetCounter().GetValue()+1 {
+		t.Errorf("expected the request to fail")
+	}
+	var up dto.Metric
+	metadataUp.Write(&up)
+	if up.GetGauge().GetValue() != 0 {
+		t.Errorf("expected metadata to be reported down")
+	}
+}
+
+func TestFetchLabelTagsAWS(t *testing.T) {
+	imds := &standInIMDS{tags: map[string]string{"Name": "web-01", "env": "prod"}}
+	srv := httptest.NewServer(imds)
+	defer srv.Close()
+
+	defer restoreMetadata()()
+	conf.UCMConfig.MetadataReporting = conf.MetaDataConf{MetadataURL: srv.URL, Provider: "aws",
+		Attributes: []conf.TagLabelMap{{TagName: []string{"env"}, LabelName: "environment"}}}
+	conf.UCMConfig.AwsTagsToLabels = conf.LabelConf{Enabled: true, TagSource: "imds"}
+	FetchMetadata()
+	FetchLabelTags()
+	if tags := HostTags(); tags["Name"] != "web-01" || tags["env"] != "prod" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	if len(TagLabelValues) != 1 || TagLabelValues[0] != "prod" {
+		t.Errorf("unexpected label values %v", TagLabelValues)
+	}
+	var source, refresh dto.Metric
+	tagsSource.WithLabelValues("imds").Write(&source)
+	tagsLastRefresh.Write(&refresh)
+	if source.GetGauge().GetValue() != 1 || refresh.GetGauge().GetValue() == 0 {
+		t.Errorf("expected the metadata service to be reported as the source")
+	}
+
+	// without metadata tags on the instance, the tags are kept as they were
+	imds.Lock()
+	imds.tags = nil
+	imds.Unlock()
+	labels["env"] = "test"
+	FetchLabelTags()
+	if tags := HostTags(); tags["env"] != "test" {
+		t.Errorf("expected tags to be kept, got %v", tags)
+	}
+}
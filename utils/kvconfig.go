@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/djonnala/wmi_exporter/log"
+	consul "github.com/hashicorp/consul/api"
+)
+
+// defaultWaitTime bounds a blocking query on the configuration key when RemoteConfig.WaitTime is not set, in seconds
+const defaultWaitTime = 300
+
+// keyPlaceholder matches the placeholders of RemoteConfig.Key
+var keyPlaceholder = regexp.MustCompile(`\{(datacenter|hostname|tag:[^}]+)\}`)
+
+// ExpandKey replaces the placeholders of a RemoteConfig.Key: {datacenter} with the datacenter of c, {hostname} with
+// the host name and {tag:NAME} with the value of a tag of the host, which must be set
+func ExpandKey(key string, c conf.ConsulConf) (string, error) {
+	defer trace()()
+	tags := HostTags()
+	var missing []string
+	expanded := keyPlaceholder.ReplaceAllStringFunc(key, func(p string) string {
+		name := p[1 : len(p)-1]
+		switch name {
+		case "datacenter":
+			return c.Datacenter
+		case "hostname":
+			return hostname
+		}
+		v, ok := tags[name[len("tag:"):]]
+		if !ok {
+			missing = append(missing, name[len("tag:"):])
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("cannot expand configuration key %s, the host has no tag %v", key, missing)
+	}
+	return expanded, nil
+}
+
+// ConfigWatcher follows a configuration document in Consul KV, keeping a copy on disk for when Consul is unavailable
+type ConfigWatcher struct {
+	conf      conf.ConsulConf
+	key       string
+	cacheFile string
+	waitTime  time.Duration
+
+	// index and last are the Consul index and content of the document last seen, last is nil if the key is missing
+	index uint64
+	last  []byte
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan bool
+}
+
+// NewConfigWatcher returns a watcher of the document configured in r, read with the connection settings of c
+func NewConfigWatcher(c conf.ConsulConf, r conf.RemoteConfigConf) (*ConfigWatcher, error) {
+	defer trace()()
+	key, err := ExpandKey(r.Key, c)
+	if err != nil {
+		return nil, err
+	}
+	waitTime := time.Duration(r.WaitTime) * time.Second
+	if r.WaitTime <= 0 {
+		waitTime = defaultWaitTime * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ConfigWatcher{conf: c, key: key, cacheFile: r.CacheFile, waitTime: waitTime, ctx: ctx, cancel: cancel}, nil
+}
+
+// Key returns the KV key watched, with its placeholders expanded
+func (w *ConfigWatcher) Key() string {
+	return w.key
+}
+
+// Load reads the document from Consul, or from the cache file if Consul cannot be reached. It returns nil when the
+// key does not exist, or when neither Consul nor the cache have the document.
+func (w *ConfigWatcher) Load() ([]byte, error) {
+	defer trace()()
+	data, err := w.get(0)
+	if err == nil {
+		return data, nil
+	}
+	consulErrors.WithLabelValues("config").Inc()
+	if w.cacheFile == "" {
+		return nil, err
+	}
+	cached, cerr := ioutil.ReadFile(w.cacheFile)
+	if cerr != nil {
+		return nil, fmt.Errorf("%s, and no cached copy: %s", err, cerr)
+	}
+	log.Warnf("%s, using the cached copy %s", conf.Redact(err.Error()), w.cacheFile)
+	w.last = cached
+	return cached, nil
+}
+
+// get runs a query on the key, blocking until its index passes index if that is not 0, and records the result
+func (w *ConfigWatcher) get(index uint64) ([]byte, error) {
+	client, err := consulClient(w.conf)
+	if err != nil {
+		return nil, err
+	}
+	q := &consul.QueryOptions{Datacenter: w.conf.Datacenter, WaitIndex: index, WaitTime: w.waitTime}
+	pair, meta, err := client.KV().Get(w.key, q.WithContext(w.ctx))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read configuration key %s: %s", w.key, err)
+	}
+	w.index = meta.LastIndex
+	var data []byte
+	if pair != nil {
+		data = pair.Value
+	}
+	w.last = data
+	if w.cacheFile != "" {
+		w.cache(data)
+	}
+	return data, nil
+}
+
+// cache keeps the document in the cache file, removing the file when the key is gone
+func (w *ConfigWatcher) cache(data []byte) {
+	var err error
+	if data == nil {
+		if err = os.Remove(w.cacheFile); os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		err = writeFileAtomic(w.cacheFile, data)
+	}
+	if err != nil {
+		log.Warnf("Cannot cache configuration key %s: %s", w.key, err)
+	}
+}
+
+// Start calls apply with every new version of the document, nil once the key is deleted, until Stop. Failed queries
+// are retried with backoff.
+func (w *ConfigWatcher) Start(apply func(data []byte)) {
+	defer trace()()
+	w.done = make(chan bool)
+	go w.watch(apply)
+}
+
+func (w *ConfigWatcher) watch(apply func(data []byte)) {
+	defer close(w.done)
+	backoff := minBackoff
+	for {
+		previous := w.last
+		index := w.index
+		data, err := w.get(index)
+		if w.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			consulErrors.WithLabelValues("config").Inc()
+			log.Errorf("%s, retrying in %s", conf.Redact(err.Error()), backoff)
+			select {
+			case <-time.After(backoff):
+			case <-w.ctx.Done():
+				return
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+		// Consul resets its index on a restore or a snapshot, start over then
+		if w.index < index {
+			w.index = 0
+		}
+		if (data == nil) != (previous == nil) || !bytes.Equal(data, previous) {
+			apply(data)
+		}
+	}
+}
+
+// Stop ends the watch begun by Start
+func (w *ConfigWatcher) Stop() {
+	defer trace()()
+	w.cancel()
+	if w.done != nil {
+		<-w.done
+	}
+}
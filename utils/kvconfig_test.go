@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+// standInKV serves a single Consul KV key, answering blocking queries once the value changes
+type standInKV struct {
+	sync.Mutex
+	index   uint64
+	value   []byte
+	changed chan bool
+}
+
+func (kv *standInKV) set(value string) {
+	kv.Lock()
+	defer kv.Unlock()
+	kv.index++
+	kv.value = []byte(value)
+	close(kv.changed)
+	kv.changed = make(chan bool)
+}
+
+func (kv *standInKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/kv/wmi_exporter/dc1/config" {
+		http.NotFound(w, r)
+		return
+	}
+	kv.Lock()
+	index, changed := kv.index, kv.changed
+	kv.Unlock()
+	if wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); wait > 0 && wait == index {
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	kv.Lock()
+	defer kv.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(kv.index, 10))
+	json.NewEncoder(w).Encode([]map[string]interface{}{{"Key": "wmi_exporter/dc1/config", "Value": kv.value}})
+}
+
+func TestConfigWatcher(t *testing.T) {
+	kv := &standInKV{changed: make(chan bool)}
+	kv.set("v1")
+	srv := httptest.NewServer(kv)
+
+	dir, err := ioutil.TempDir("", "kvconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := filepath.Join(dir, "remote.toml")
+
+	c := standInConf(t, srv.URL)
+	c.Datacenter = "dc1"
+	w, err := NewConfigWatcher(c, conf.RemoteConfigConf{Key: "wmi_exporter/{datacenter}/config", CacheFile: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := w.Load(); err != nil || string(data) != "v1" {
+		t.Fatalf("expected v1, got %q, %v", data, err)
+	}
+
+	applied := make(chan string, 1)
+	w.Start(func(data []byte) { applied <- string(data) })
+	kv.set("v2")
+	select {
+	case data := <-applied:
+		if data != "v2" {
+			t.Errorf("expected v2 to be applied, got %q", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the change")
+	}
+	w.Stop()
+	srv.Close()
+
+	if cached, _ := ioutil.ReadFile(cache); string(cached) != "v2" {
+		t.Errorf("expected v2 to be cached, got %q", cached)
+	}
+	w, _ = NewConfigWatcher(c, conf.RemoteConfigConf{Key: "wmi_exporter/{datacenter}/config", CacheFile: cache})
+	if data, err := w.Load(); err != nil || string(data) != "v2" {
+		t.Errorf("expected the cached v2 with Consul down, got %q, %v", data, err)
+	}
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"time"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/djonnala/wmi_exporter/log"
+)
+
+// MetadataProvider reads the attributes and tags of the host from the metadata service of the cloud it runs on
+type MetadataProvider interface {
+	// Name is the provider, as in MetaDataConf.Provider
+	Name() string
+	// Identity returns the attributes of the instance, e.g. its id and region, failing when the host does not run on
+	// this cloud
+	Identity() (map[string]string, error)
+	// Tags returns the tags of the instance, given its identity
+	Tags(identity map[string]string) (map[string]string, error)
+}
+
+// metadataProviders lists the providers in the order they are detected
+var metadataProviders = []string{"aws", "azure", "gce"}
+
+// provider is the metadata provider of the host and identity its attributes, nil outside of a cloud; both are
+// guarded by labelsMtx
+var (
+	provider MetadataProvider
+	identity map[string]string
+)
+
+// newMetadataProvider returns the provider called name
+func newMetadataProvider(name string, c conf.MetaDataConf) MetadataProvider {
+	defer trace()()
+	switch name {
+	case "azure":
+		return newAzureProvider(c)
+	case "gce":
+		return newGCEProvider(c)
+	}
+	return &awsProvider{imds: newIMDSClient(c)}
+}
+
+// FetchMetadata finds the metadata provider of the host, as configured or by trying each in turn, and primes the tag
+// array with the attributes of the instance, so these can be used for registration and metric labeling
+func FetchMetadata() {
+	defer trace()()
+	c := conf.UCMConfig.MetadataReporting
+	candidates := metadataProviders
+	switch c.Provider {
+	case "none":
+		return
+	case "", "auto":
+	default:
+		candidates = []string{c.Provider}
+	}
+
+	var lastErr error
+	for _, name := range candidates {
+		p := newMetadataProvider(name, c)
+		id, err := p.Identity()
+		if err != nil {
+			log.Debugf("No %s instance metadata: %s", name, conf.Redact(err.Error()))
+			lastErr = err
+			continue
+		}
+		log.Infof("Found %s instance metadata", name)
+		metadataUp.Set(1)
+		labelsMtx.Lock()
+		defer labelsMtx.Unlock()
+		provider, identity = p, id
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		for k, v := range id {
+			labels[k] = v
+		}
+		return
+	}
+	metadataUp.Set(0)
+	metadataErrors.WithLabelValues("identity").Inc()
+	log.Warnf("Failed to get the instance metadata, the metadata service may be unavailable: %s", conf.Redact(lastErr.Error()))
+}
+
+// FetchLabelTags gets the instance tags from the metadata provider and primes the tag label arrays
+func FetchLabelTags() {
+	defer trace()()
+	labelsMtx.RLock()
+	p, id := provider, identity
+	labelsMtx.RUnlock()
+	if conf.UCMConfig.AwsTagsToLabels.Enabled && p != nil {
+		tags, err := p.Tags(id)
+		if err != nil {
+			log.Warnf("Failed to get the instance tags from %s: %s", p.Name(), conf.Redact(err.Error()))
+		} else {
+			labelsMtx.Lock()
+			for k, v := range tags {
+				labels[k] = v
+			}
+			labelsMtx.Unlock()
+			tagsLastRefresh.Set(float64(time.Now().Unix()))
+		}
+	}
+	// set up the label list here so it does not have to be processed during metric collection
+	// create label name & value arrays
+	if len(TagLabelNames) == 0 {
+		TagLabelNames, TagLabelValues = getTagLabels()
+	} else {
+		TagLabelValues = getTagLabelValues(TagLabelNames)
+	}
+}
+
+// recordTagSource reports source, imds or api, as the one the current tags come from
+func recordTagSource(source string) {
+	tagsSource.Reset()
+	tagsSource.WithLabelValues(source).Set(1)
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+// restoreMetadata resets the metadata of the host and returns a function restoring it, with the configuration
+func restoreMetadata() func() {
+	saved, savedProvider, savedIdentity := conf.UCMConfig, provider, identity
+	savedLabels, savedNames, savedValues := labels, TagLabelNames, TagLabelValues
+	provider, identity, labels, TagLabelNames, TagLabelValues = nil, nil, nil, nil, nil
+	return func() {
+		conf.UCMConfig, provider, identity = saved, savedProvider, savedIdentity
+		labels, TagLabelNames, TagLabelValues = savedLabels, savedNames, savedValues
+	}
+}
+
+// standInAzure serves the compute metadata of an Azure virtual machine
+func standInAzure(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metadata/instance/compute" || r.Header.Get("Metadata") != "true" || r.URL.Query().Get("api-version") == "" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(`{"vmId": "02aab8a4-74ef-476e-8182-f6d2ba4166a6", "name": "web-01", "location": "westeurope",
+		"resourceGroupName": "rg-web", "zone": "", "tagsList": [{"name": "env", "value": "prod"}]}`))
+}
+
+func TestFetchMetadataAzure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(standInAzure))
+	defer srv.Close()
+
+	defer restoreMetadata()()
+	conf.UCMConfig.MetadataReporting = conf.MetaDataConf{MetadataURL: srv.URL,
+		Attributes: []conf.TagLabelMap{{TagName: []string{"env", "resourceGroup"}, LabelName: "group", MergeSeparator: "/"}}}
+	conf.UCMConfig.AwsTagsToLabels = conf.LabelConf{Enabled: true}
+	FetchMetadata()
+	if provider == nil || provider.Name() != "azure" {
+		t.Fatalf("expected azure to be detected, got %v", provider)
+	}
+	FetchLabelTags()
+	tags := HostTags()
+	if tags["vmId"] != "02aab8a4-74ef-476e-8182-f6d2ba4166a6" || tags["region"] != "westeurope" || tags["env"] != "prod" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	if _, ok := tags["zone"]; ok {
+		t.Errorf("expected empty attributes to be left out")
+	}
+	if len(TagLabelValues) != 1 || TagLabelValues[0] != "prod/rg-web" {
+		t.Errorf("unexpected label values %v", TagLabelValues)
+	}
+}
+
+func TestFetchMetadataGCE(t *testing.T) {
+	compute := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/compute/v1/projects/acme/zones/europe-west1-b/instances/web-01" || r.Header.Get("Authorization") != "Bearer ya29" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"labels": {"env": "prod"}}`))
+	}))
+	defer compute.Close()
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/":
+			w.Write([]byte(`{"id": 4520031799277581759, "name": "web-01", "zone": "projects/1234/zones/europe-west1-b",
+				"machineType": "projects/1234/machineTypes/n1-standard-2"}`))
+		case "/computeMetadata/v1/project/project-id":
+			w.Write([]byte("acme"))
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			w.Write([]byte(`{"access_token": "ya29", "expires_in": 3599, "token_type": "Bearer"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer metadata.Close()
+
+	defer restoreMetadata()()
+	p := newGCEProvider(conf.MetaDataConf{MetadataURL: metadata.URL})
+	p.computeURL = compute.URL
+	id, err := p.Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id["instanceId"] != "4520031799277581759" || id["zone"] != "europe-west1-b" || id["region"] != "europe-west1" ||
+		id["projectId"] != "acme" || id["machineType"] != "n1-standard-2" {
+		t.Errorf("unexpected identity %v", id)
+	}
+	tags, err := p.Tags(id)
+	if err != nil || tags["env"] != "prod" {
+		t.Errorf("unexpected labels %v, %v", tags, err)
+	}
+}
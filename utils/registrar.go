@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/djonnala/wmi_exporter/conf"
+)
+
+// Registrar announces the exporter to a service discovery backend
+type Registrar interface {
+	// Register announces the exporter with some metadata, replacing any earlier announcement
+	Register(md ServiceMetadata) error
+	// Registered tells whether the announcement still exists
+	Registered() (bool, error)
+	// Deregister withdraws the announcement
+	Deregister() error
+}
+
+// newRegistrar returns the Registrar of the backend configured in c, and the metadata it announces
+func newRegistrar(c conf.ConsulConf, node string, address string, port int) (Registrar, func() ServiceMetadata, error) {
+	defer trace()()
+	switch c.Backend {
+	case "", "consul":
+		return consulRegistrar{conf: c, node: node, address: address, port: port}, serviceInfo, nil
+	case "file_sd":
+		// file_sd targets carry the metadata as labels, whatever LegacyTags says
+		return newFileSDRegistrar(c.FileSDPath, address, port), func() ServiceMetadata { return ServiceMetadata{Meta: metadataToMeta()} }, nil
+	}
+	return nil, nil, fmt.Errorf("unknown service discovery backend '%s'", c.Backend)
+}
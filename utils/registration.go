@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"time"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/djonnala/wmi_exporter/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// defaultRefreshInterval is how often, in seconds, the registration is checked when RefreshInterval is not set
+	defaultRefreshInterval = 60
+	// minBackoff and maxBackoff bound the wait before retrying a failed registration
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+var (
+	consulRegistered = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "wmi_exporter",
+			Subsystem: "consul",
+			Name:      "registered",
+			Help:      "wmi_exporter: Whether the exporter is registered with its service discovery backend.",
+		},
+	)
+	consulErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "wmi_exporter",
+			Subsystem: "consul",
+			Name:      "errors_total",
+			Help:      "wmi_exporter: Failed service discovery requests, by operation.",
+		},
+		[]string{"operation"},
+	)
+
+	// registration keeps the exporter registered between Register and DeRegister
+	registration *registrationManager
+)
+
+func init() {
+	prometheus.MustRegister(consulRegistered, consulErrors)
+}
+
+// registrationManager keeps the exporter registered with a service discovery backend: it retries a failed
+// registration with exponential backoff, checks every interval that the registration still exists and registers again
+// when it is gone or the metadata reported has changed
+type registrationManager struct {
+	registrar Registrar
+	md        func() ServiceMetadata
+
+	interval   time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	quit chan bool
+	done chan bool
+}
+
+func newRegistrationManager(registrar Registrar, md func() ServiceMetadata, refreshInterval int) *registrationManager {
+	defer trace()()
+	interval := time.Duration(refreshInterval) * time.Second
+	if refreshInterval <= 0 {
+		interval = defaultRefreshInterval * time.Second
+	}
+	return &registrationManager{
+		registrar:  registrar,
+		md:         md,
+		interval:   interval,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+	}
+}
+
+// start registers in the background and keeps the registration up until stop
+func (m *registrationManager) start() {
+	defer trace()()
+	m.quit = make(chan bool)
+	m.done = make(chan bool)
+	go m.run()
+}
+
+// stop ends the background work and deregisters
+func (m *registrationManager) stop() {
+	defer trace()()
+	close(m.quit)
+	<-m.done
+	if err := m.registrar.Deregister(); err != nil {
+		consulErrors.WithLabelValues("deregister").Inc()
+		log.Error(conf.Redact(err.Error()))
+	} else {
+		log.Infoln("OK: Deregistration succeeded.")
+	}
+	consulRegistered.Set(0)
+}
+
+func (m *registrationManager) run() {
+	defer close(m.done)
+	var registered ServiceMetadata
+	ok := false
+	backoff := m.minBackoff
+	for {
+		wait := m.interval
+		md := m.md()
+		if !ok || m.needsRegistration(registered, md) {
+			if err := m.registrar.Register(md); err != nil {
+				consulErrors.WithLabelValues("register").Inc()
+				consulRegistered.Set(0)
+				log.Errorf("%s, retrying in %s", conf.Redact(err.Error()), backoff)
+				ok = false
+				wait = backoff
+				if backoff *= 2; backoff > m.maxBackoff {
+					backoff = m.maxBackoff
+				}
+			} else {
+				log.Infof("OK: Registration succeeded with %s.", md)
+				consulRegistered.Set(1)
+				registered, ok = md, true
+				backoff = m.minBackoff
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// needsRegistration tells whether the exporter, registered with some metadata, has to be registered again because
+// the metadata changed or Consul no longer knows it
+func (m *registrationManager) needsRegistration(registered ServiceMetadata, md ServiceMetadata) bool {
+	if registered.String() != md.String() {
+		log.Infof("Registration metadata changed from %s to %s.", registered, md)
+		return true
+	}
+	ok, err := m.registrar.Registered()
+	if err != nil {
+		consulErrors.WithLabelValues("check").Inc()
+		log.Error(conf.Redact(err.Error()))
+		return true
+	}
+	if !ok {
+		log.Warnln("Registration is gone, registering again.")
+	}
+	return !ok
+}
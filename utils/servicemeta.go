@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/djonnala/wmi_exporter/conf"
+	"github.com/djonnala/wmi_exporter/log"
+)
+
+const (
+	// maxMetaPairs, maxMetaKeyLength and maxMetaValueLength are the limits Consul puts on service metadata
+	maxMetaPairs       = 64
+	maxMetaKeyLength   = 128
+	maxMetaValueLength = 512
+	// reservedMetaPrefix starts the metadata keys Consul keeps for itself
+	reservedMetaPrefix = "consul-"
+)
+
+// invalidMetaKeyChars matches what Consul does not accept in metadata keys
+var invalidMetaKeyChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// ServiceMetadata is what the exporter reports about the host when registering: MetadataReporting.Attributes as
+// service metadata, or as key=value; tags with MetadataReporting.LegacyTags
+type ServiceMetadata struct {
+	Tags []string
+	Meta map[string]string
+}
+
+// String identifies the content of the metadata, to compare it with an earlier registration
+func (m ServiceMetadata) String() string {
+	// fmt prints maps sorted by key
+	return fmt.Sprintf("tags %v, meta %v", m.Tags, m.Meta)
+}
+
+// serviceInfo returns the metadata to register with, from the currently cached tags
+func serviceInfo() ServiceMetadata {
+	defer trace()()
+	if conf.UCMConfig.MetadataReporting.LegacyTags {
+		return ServiceMetadata{Tags: metadataToTags()}
+	}
+	return ServiceMetadata{Meta: metadataToMeta()}
+}
+
+// metadataToMeta maps the instance tags to service metadata, with keys sanitized to what Consul accepts
+func metadataToMeta() map[string]string {
+	defer trace()()
+	if !conf.UCMConfig.MetadataReporting.Enabled {
+		return nil
+	}
+	return sanitizeMeta(processTagLabelMap(HostTags(), conf.UCMConfig.MetadataReporting.Attributes))
+}
+
+// sanitizeMeta rewrites metadata to follow the rules of Consul: keys of at most 128 letters, digits, dashes and
+// underscores not starting with consul-, values of at most 512 characters and no more than 64 pairs. Characters not
+// allowed in keys become underscores; reserved keys and the pairs beyond the limit, in key order, are dropped.
+func sanitizeMeta(m map[string]string) map[string]string {
+	defer trace()()
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	meta := make(map[string]string, len(m))
+	for _, k := range keys {
+		key := invalidMetaKeyChars.ReplaceAllString(k, "_")
+		if len(key) > maxMetaKeyLength {
+			key = key[:maxMetaKeyLength]
+		}
+		if key == "" || strings.HasPrefix(strings.ToLower(key), reservedMetaPrefix) {
+			log.Warnf("Dropping service metadata %q: the key is reserved by Consul.", k)
+			continue
+		}
+		if _, ok := meta[key]; ok {
+			log.Warnf("Dropping service metadata %q: it is the same as another key once sanitized.", k)
+			continue
+		}
+		if len(meta) == maxMetaPairs {
+			log.Warnf("Dropping service metadata %q: Consul accepts at most %d pairs.", k, maxMetaPairs)
+			continue
+		}
+		value := m[k]
+		if len(value) > maxMetaValueLength {
+			value = value[:maxMetaValueLength]
+		}
+		meta[key] = value
+	}
+	return meta
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeMeta(t *testing.T) {
+	meta := sanitizeMeta(map[string]string{
+		"aws:cloudformation:stack": "web",
+		"Consul-Version":           "1.0",
+		"cost center":              strings.Repeat("x", 600),
+		"role":                     "web",
+	})
+	expected := map[string]string{
+		"aws_cloudformation_stack": "web",
+		"cost_center":              strings.Repeat("x", 512),
+		"role":                     "web",
+	}
+	if !reflect.DeepEqual(meta, expected) {
+		t.Errorf("expected %v, got %v", expected, meta)
+	}
+}
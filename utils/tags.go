@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// tag sources, as in LabelConf.TagSource
+const (
+	tagSourceIMDS = "imds"
+	tagSourceAPI  = "api"
+)
+
+var (
+	tagsSource = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "wmi_exporter",
+			Subsystem: "metadata",
+			Name:      "tags_source",
+			Help:      "wmi_exporter: The source of the instance tags last fetched, 1 for that source.",
+		},
+		[]string{"source"},
+	)
+	tagsLastRefresh = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "wmi_exporter",
+			Subsystem: "metadata",
+			Name:      "tags_last_refresh_timestamp_seconds",
+			Help:      "wmi_exporter: When the instance tags were last fetched successfully, in seconds since the epoch.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(tagsSource, tagsLastRefresh)
+}
+
+// tagSourceOrder returns the sources to try in order for a LabelConf.TagSource
+func tagSourceOrder(source string) []string {
+	switch source {
+	case tagSourceIMDS, tagSourceAPI:
+		return []string{source}
+	}
+	// the metadata service needs neither IAM permissions nor egress, try it first
+	return []string{tagSourceIMDS, tagSourceAPI}
+}
+
+// errTagsDisabled tells that the instance does not allow tags in metadata
+var errTagsDisabled = errors.New("instance metadata tags are not enabled on the instance")
+
+// imdsTags reads the instance tags from the tags/instance endpoint of the metadata service, listing the keys and then
+// reading each value. The endpoint is not found unless the instance allows tags in metadata.
+func imdsTags(imds *imdsClient) (map[string]string, error) {
+	defer trace()()
+	list, err := imds.get("meta-data/tags/instance")
+	if err == statusError(http.StatusNotFound) {
+		return nil, errTagsDisabled
+	}
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for _, key := range strings.Split(string(list), "\n") {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+		value, err := imds.get("meta-data/tags/instance/" + key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read tag %s: %s", key, err)
+		}
+		tags[key] = string(value)
+	}
+	return tags, nil
+}
+
+// apiTags reads the tags of instanceID in region with ec2:DescribeTags
+func apiTags(imds *imdsClient, instanceID string, region string) (map[string]string, error) {
+	defer trace()()
+	// the instance role comes from the metadata service as configured, so that IMDSv2 and custom endpoints also
+	// apply to the credentials
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(region),
+		Credentials: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvProvider{},
+			&credentials.SharedCredentialsProvider{},
+			&imdsRoleProvider{imds: imds},
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	svc := ec2.New(sess)
+	params := &ec2.DescribeTagsInput{
+		Filters: []*ec2.Filter{
+			{
+				Name: aws.String("resource-id"),
+				Values: []*string{
+					aws.String(instanceID),
+				},
+			},
+		},
+	}
+
+	describeTagsRes, err := svc.DescribeTags(params)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(describeTagsRes.Tags))
+	for _, tag := range describeTagsRes.Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return tags, nil
+}